@@ -0,0 +1,176 @@
+package main
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// loadBase loads the packages matching the patterns, and their
+// dependencies, at the base of the range. It returns the packages and the
+// root of the worktree their directories are in.
+func loadBase(opts *options, buildCtx build.Context) ([]packages.Package, string, error) {
+	rev := opts.rng.Base
+	if rev == "" {
+		rev = "HEAD"
+	}
+	pkgs, worktree, err := loadRevision(opts, buildCtx, rev)
+	if err != nil {
+		return nil, "", errors.Annotate(err, "loading packages at the base")
+	}
+	return pkgs, worktree, nil
+}
+
+// loadRevision loads the packages matching the patterns, and their
+// dependencies, at rev from a temporary git worktree, which is removed
+// before returning. It returns the packages and the root of the worktree
+// their directories are in, or no packages if the working directory did not
+// exist at rev.
+func loadRevision(opts *options, buildCtx build.Context, rev string) ([]packages.Package, string, error) {
+	worktree, err := git.AddWorktree(opts.gitRoot, rev)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	defer git.RemoveWorktree(opts.gitRoot, worktree)
+
+	rel, err := filepath.Rel(opts.gitRoot, opts.wd)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	wd := filepath.Join(worktree, rel)
+	if _, err := os.Stat(wd); os.IsNotExist(err) {
+		return nil, worktree, nil
+	}
+	pkgs, err := loadPackages(buildCtx, wd, opts.patterns)
+	if err != nil {
+		return nil, "", errors.Trace(err)
+	}
+	return pkgs, worktree, nil
+}
+
+// loadPackages loads the packages matching the patterns, relative to wd,
+// and their dependencies, from the workspace or from each module the
+// patterns span.
+func loadPackages(buildCtx build.Context, wd string, patterns []string) ([]packages.Package, error) {
+	workFile, err := packages.Workspace(buildCtx, wd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	moduleDirs, err := packages.Modules(buildCtx, wd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	patterns = packages.ExpandPatterns(wd, moduleDirs, patterns)
+	var pkgs, extraPkgs []packages.Package
+	if workFile != "" {
+		pkgs, extraPkgs, err = packages.ImportAll(buildCtx, wd, patterns)
+	} else {
+		pkgs, extraPkgs, err = packages.ImportModules(buildCtx, packages.GroupPatterns(wd, moduleDirs, patterns))
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return append(pkgs, extraPkgs...), nil
+}
+
+// compareBase marks the packages affected by packages of the repository
+// that were removed since the base, and those whose imports changed. Former
+// importers of a removed package, and packages still importing it, are
+// changed, or only their tests if only their tests import it. The packages
+// marked are added to whole, as their changes cannot be narrowed to
+// objects.
+func (s *selection) compareBase(basePkgs []packages.Package, worktree, gitRoot string, whole map[string]bool) {
+	base := map[string]packages.Package{}
+	for _, pkg := range basePkgs {
+		if packages.Within(pkg.Dir, worktree) && hasFiles(pkg) {
+			base[pkg.ImportPath] = pkg
+		}
+	}
+	head := map[string]packages.Package{}
+	for importPath, pkg := range s.pkgsByPath {
+		if packages.Within(pkg.Dir, gitRoot) && hasFiles(pkg) {
+			head[importPath] = pkg
+		}
+	}
+
+	changed := func(importPath string, reason Reason) {
+		if _, ok := head[importPath]; !ok {
+			return
+		}
+		s.changedPackages[importPath] = true
+		s.whyChanged[importPath] = append(s.whyChanged[importPath], reason)
+		whole[importPath] = true
+	}
+	testsChanged := func(importPath string, reason Reason) {
+		if _, ok := head[importPath]; !ok {
+			return
+		}
+		s.changedTestPackages[importPath] = true
+		s.whyChangedTests[importPath] = append(s.whyChangedTests[importPath], reason)
+	}
+
+	for importPath := range base {
+		if _, ok := head[importPath]; ok {
+			continue
+		}
+		reason := Reason{Code: ReasonPackageRemoved, Path: importPath}
+		for _, pkgs := range []map[string]packages.Package{base, s.pkgsByPath} {
+			for _, pkg := range pkgs {
+				if contains(pkg.Imports, importPath) {
+					changed(pkg.ImportPath, reason)
+				} else if contains(pkg.TestImports, importPath) || contains(pkg.XTestImports, importPath) {
+					testsChanged(pkg.ImportPath, reason)
+				}
+			}
+		}
+	}
+
+	for _, pkg := range s.pkgs {
+		past, ok := base[pkg.ImportPath]
+		if !ok {
+			if _, ok := head[pkg.ImportPath]; ok {
+				changed(pkg.ImportPath, Reason{Code: ReasonPackageAdded, Path: pkg.ImportPath})
+			}
+			continue
+		}
+		if !sameSet(past.Imports, pkg.Imports) {
+			changed(pkg.ImportPath, Reason{Code: ReasonImportsChanged, Path: pkg.ImportPath})
+		}
+		pastTests := append(append([]string(nil), past.TestImports...), past.XTestImports...)
+		tests := append(append([]string(nil), pkg.TestImports...), pkg.XTestImports...)
+		if !sameSet(pastTests, tests) {
+			testsChanged(pkg.ImportPath, Reason{Code: ReasonTestImportsChanged, Path: pkg.ImportPath})
+		}
+	}
+}
+
+// hasFiles reports whether the package has any Go files, which packages
+// that failed to load, such as removed ones, do not.
+func hasFiles(pkg packages.Package) bool {
+	return len(pkg.GoFiles)+len(pkg.CgoFiles)+len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) > 0
+}
+
+// sameSet reports whether two lists contain the same strings, ignoring
+// order and duplicates.
+func sameSet(a, b []string) bool {
+	set := func(list []string) string {
+		seen := map[string]bool{}
+		sorted := []string(nil)
+		for _, v := range list {
+			if !seen[v] {
+				seen[v] = true
+				sorted = append(sorted, v)
+			}
+		}
+		sort.Strings(sorted)
+		return strings.Join(sorted, "\n")
+	}
+	return set(a) == set(b)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/gomod"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// classifier assigns changed files to the packages they are build or test
+// inputs of. Files that are neither are recorded as ignored, with a reason.
+type classifier struct {
+	gitRoot   string
+	rng       git.Range
+	ignore    []string
+	comments  bool
+	pkgsByDir map[string]packages.Package
+	roots     []string
+	embeds    map[string][]embedder
+	includes  map[string][]string
+
+	// build and test map package directories to their changed build and
+	// test inputs.
+	build   map[string][]git.Change
+	test    map[string][]git.Change
+	ignored []ignoredChange
+}
+
+// ignoredChange is a changed file that is not an input of any package, or
+// a change to a go.mod directive, its Event, that affects no packages.
+type ignoredChange struct {
+	git.Change
+	Reason string
+	Event  *gomod.Event
+}
+
+func newClassifier(opts *options, pkgs []packages.Package) *classifier {
+	pkgsByDir := map[string]packages.Package{}
+	roots := []string{path.Clean(opts.gitRoot)}
+	for _, pkg := range pkgs {
+		pkgsByDir[path.Clean(pkg.Dir)] = pkg
+		if dir := moduleDir(pkg); dir != "" && !contains(roots, path.Clean(dir)) {
+			roots = append(roots, path.Clean(dir))
+		}
+	}
+	return &classifier{
+		gitRoot:   opts.gitRoot,
+		rng:       opts.rng,
+		ignore:    opts.ignore,
+		comments:  opts.ignoreComments,
+		pkgsByDir: pkgsByDir,
+		roots:     roots,
+		embeds:    embedders(pkgs),
+		includes:  includers(pkgs),
+		build:     map[string][]git.Change{},
+		test:      map[string][]git.Change{},
+	}
+}
+
+func (c *classifier) add(change git.Change) {
+	if pattern, ok := matchIgnore(c.ignore, gitPath(c.gitRoot, change.Path)); ok {
+		c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: fmt.Sprintf("matches --ignore %s", pattern)})
+		return
+	}
+	if path.Base(change.Path) == "modules.txt" && path.Base(path.Dir(change.Path)) == "vendor" {
+		c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: "vendor manifest, compared separately"})
+		return
+	}
+
+	used := false
+	for _, dir := range c.includes[change.Path] {
+		c.build[dir] = append(c.build[dir], change)
+		used = true
+	}
+	// Embedded files belong to the embedding packages, or only their
+	// tests, wherever they are.
+	owners := c.embeds[change.Path]
+	if len(owners) == 0 {
+		// Removed and renamed files are not in the head's embedded files,
+		// but were embedded if they match an embed pattern.
+		owners = c.patternEmbedders(change.Path)
+	}
+	if len(owners) > 0 {
+		for _, owner := range owners {
+			if owner.test {
+				c.test[owner.dir] = append(c.test[owner.dir], change)
+			} else {
+				c.build[owner.dir] = append(c.build[owner.dir], change)
+			}
+		}
+		return
+	}
+
+	dir := path.Dir(change.Path)
+	if owner, ok := testdataOwner(dir, c.roots, c.pkgsByDir); ok {
+		if owner != "" {
+			c.test[owner] = append(c.test[owner], change)
+		} else if !used {
+			c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: "testdata with no owning package"})
+		}
+		return
+	}
+
+	pkg, ok := c.pkgsByDir[dir]
+	if !ok {
+		if !used {
+			c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: "not in a compared package directory"})
+		}
+		return
+	}
+	kind, reason := classifyFile(pkg, change.Path)
+	// Only the package's own Go files are compiled, so the comments of
+	// testdata and embedded files are content.
+	if kind != fileIgnored && c.comments && isGoFile(pkg, path.Base(change.Path)) && commentOnly(c.gitRoot, c.rng, change.Path) {
+		c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: "comment-only"})
+		return
+	}
+	switch kind {
+	case fileBuild:
+		c.build[dir] = append(c.build[dir], change)
+	case fileTest:
+		c.test[dir] = append(c.test[dir], change)
+	default:
+		if !used {
+			c.ignored = append(c.ignored, ignoredChange{Change: change, Reason: reason})
+		}
+	}
+}
+
+// matchIgnore returns the first pattern matching file, a slash separated
+// path relative to the git root. Patterns without a slash match the base
+// name of the file.
+func matchIgnore(patterns []string, file string) (string, bool) {
+	for _, pattern := range patterns {
+		name := file
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(file)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+type fileKind int
+
+const (
+	fileIgnored fileKind = iota
+	fileBuild
+	fileTest
+)
+
+// sourceExts are the extensions of files the go command may build.
+var sourceExts = map[string]bool{
+	".go": true, ".c": true, ".cc": true, ".cpp": true, ".cxx": true,
+	".m": true, ".h": true, ".hh": true, ".hpp": true, ".hxx": true,
+	".f": true, ".F": true, ".for": true, ".f90": true, ".s": true,
+	".S": true, ".sx": true, ".swig": true, ".swigcxx": true, ".syso": true,
+}
+
+// classifyFile decides whether file, in pkg's directory, is a build or test
+// input of pkg, using the file lists from go list. Files that no longer
+// exist are classified by their name. The reason describes ignored files.
+func classifyFile(pkg packages.Package, file string) (fileKind, string) {
+	name := path.Base(file)
+	switch name {
+	case "go.mod", "go.sum", "go.work", "go.work.sum":
+		return fileIgnored, "module file, compared separately"
+	}
+	for _, files := range [][]string{
+		pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.MFiles,
+		pkg.HFiles, pkg.FFiles, pkg.SFiles, pkg.SwigFiles, pkg.SwigCXXFiles,
+		pkg.SysoFiles,
+	} {
+		if contains(files, name) {
+			return fileBuild, ""
+		}
+	}
+	if contains(pkg.TestGoFiles, name) || contains(pkg.XTestGoFiles, name) {
+		return fileTest, ""
+	}
+	if contains(pkg.IgnoredGoFiles, name) || contains(pkg.IgnoredOtherFiles, name) {
+		return fileIgnored, fmt.Sprintf("excluded by build constraints from %s", pkg.ImportPath)
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if strings.HasSuffix(name, "_test.go") {
+			return fileTest, ""
+		}
+		if sourceExts[path.Ext(name)] {
+			return fileBuild, ""
+		}
+	}
+	return fileIgnored, fmt.Sprintf("not a build or test input of %s", pkg.ImportPath)
+}
+
+// isGoFile reports whether name is one of the Go files of pkg, or of its
+// tests.
+func isGoFile(pkg packages.Package, name string) bool {
+	for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+		if contains(files, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// testdataOwner returns the directory of the package owning a file in dir
+// when dir is inside a testdata directory below its module or git root, the
+// innermost of roots containing it. The go command ignores testdata
+// directories, so the owner is the nearest package directory above the
+// outermost testdata element, and no further up than the root.
+func testdataOwner(dir string, roots []string, pkgsByDir map[string]packages.Package) (string, bool) {
+	root := ""
+	for _, r := range roots {
+		if packages.Within(dir, r) && len(r) > len(root) {
+			root = r
+		}
+	}
+	if root == "" {
+		return "", false
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(dir, root), "/")
+	if rel == "" {
+		return "", false
+	}
+	elems := strings.Split(rel, "/")
+	for i, elem := range elems {
+		if elem != "testdata" {
+			continue
+		}
+		for owner := path.Join(root, strings.Join(elems[:i], "/")); packages.Within(owner, root); owner = path.Dir(owner) {
+			if _, ok := pkgsByDir[owner]; ok {
+				return owner, true
+			}
+			if owner == root {
+				break
+			}
+		}
+		return "", true
+	}
+	return "", false
+}
+
+// embedder is a package embedding a file with //go:embed.
+type embedder struct {
+	dir  string
+	test bool
+}
+
+// embedders maps each file embedded by pkgs to the packages embedding it.
+func embedders(pkgs []packages.Package) map[string][]embedder {
+	embeds := map[string][]embedder{}
+	for _, pkg := range pkgs {
+		dir := path.Clean(pkg.Dir)
+		add := func(files []string, test bool) {
+			for _, file := range files {
+				file = filepath.Join(dir, file)
+				embeds[file] = append(embeds[file], embedder{dir: dir, test: test})
+			}
+		}
+		add(embedFiles(dir, pkg.EmbedFiles, pkg.EmbedPatterns), false)
+		add(embedFiles(dir, pkg.TestEmbedFiles, pkg.TestEmbedPatterns), true)
+		add(embedFiles(dir, pkg.XTestEmbedFiles, pkg.XTestEmbedPatterns), true)
+	}
+	return embeds
+}
+
+// patternEmbedders returns the packages with an embed pattern matching
+// file, which are in directories above it.
+func (c *classifier) patternEmbedders(file string) []embedder {
+	owners := []embedder(nil)
+	for dir := path.Dir(file); ; dir = path.Dir(dir) {
+		if pkg, ok := c.pkgsByDir[dir]; ok {
+			rel := strings.TrimPrefix(file, dir+"/")
+			if embedMatches(pkg.EmbedPatterns, rel) {
+				owners = append(owners, embedder{dir: dir})
+			}
+			if embedMatches(pkg.TestEmbedPatterns, rel) || embedMatches(pkg.XTestEmbedPatterns, rel) {
+				owners = append(owners, embedder{dir: dir, test: true})
+			}
+		}
+		if dir == path.Dir(dir) {
+			return owners
+		}
+	}
+}
+
+// embedMatches reports whether a //go:embed pattern matches file, a slash
+// separated path relative to the package directory, either itself or as a
+// file in a matched directory. Files in such directories whose names begin
+// with . or _ are excluded unless the pattern has the all: prefix.
+func embedMatches(patterns []string, file string) bool {
+	elems := strings.Split(file, "/")
+	for _, pattern := range patterns {
+		all := strings.HasPrefix(pattern, "all:")
+		pattern = strings.TrimPrefix(pattern, "all:")
+		n := len(strings.Split(pattern, "/"))
+		if n > len(elems) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, strings.Join(elems[:n], "/")); !ok {
+			continue
+		}
+		hidden := false
+		for _, elem := range elems[n:] {
+			hidden = hidden || strings.HasPrefix(elem, ".") || strings.HasPrefix(elem, "_")
+		}
+		if all || !hidden {
+			return true
+		}
+	}
+	return false
+}
+
+// embedFiles returns files, or when go list did not resolve them, as it
+// does not for test embeds without -test, the files matched by patterns.
+func embedFiles(dir string, files, patterns []string) []string {
+	if len(files) > 0 || len(patterns) == 0 {
+		return files
+	}
+	for _, pattern := range patterns {
+		all := strings.HasPrefix(pattern, "all:")
+		matches, err := filepath.Glob(filepath.Join(dir, strings.TrimPrefix(pattern, "all:")))
+		if err != nil {
+			continue
+		}
+		for _, match := range matches {
+			filepath.WalkDir(match, func(file string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return nil
+				}
+				name := d.Name()
+				if file != match && !all && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+					if d.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if !d.IsDir() {
+					if rel, err := filepath.Rel(dir, file); err == nil {
+						files = append(files, rel)
+					}
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+// includers maps each file included by the cgo, C and assembly sources of
+// pkgs from outside the including package's directory to the directories of
+// the packages including it.
+func includers(pkgs []packages.Package) map[string][]string {
+	includes := map[string][]string{}
+	for _, pkg := range pkgs {
+		dir := path.Clean(pkg.Dir)
+		for _, file := range packages.Includes(pkg) {
+			if path.Dir(file) != dir {
+				includes[file] = append(includes[file], dir)
+			}
+		}
+	}
+	return includes
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/impact"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// commentOnly reports whether the Go file, an absolute path under gitRoot,
+// differs between the base and head of rng only in comments and formatting.
+// Comments that affect the build, such as build constraints, compiler
+// directives and cgo preambles, are compared too. Files that are missing on
+// either side or fail to parse are never comment-only.
+func commentOnly(gitRoot string, rng git.Range, file string) bool {
+	if !packages.Within(file, gitRoot) {
+		return false
+	}
+	past, err := readBase(gitRoot, rng, file)
+	if err != nil {
+		return false
+	}
+	current, err := readHead(gitRoot, rng, file)
+	if err != nil {
+		return false
+	}
+	pastCode, pastDirectives, ok := normalizeGo(file, past)
+	if !ok {
+		return false
+	}
+	currentCode, currentDirectives, ok := normalizeGo(file, current)
+	if !ok {
+		return false
+	}
+	if pastCode != currentCode || pastDirectives != currentDirectives {
+		return false
+	}
+	// The output comments of examples are compared with what they print.
+	return !strings.HasSuffix(file, "_test.go") || exampleOutputs(file, past) == exampleOutputs(file, current)
+}
+
+// normalizeGo returns the tokens of a Go file, which exclude comments and
+// formatting, and the comments in it that affect the build.
+func normalizeGo(file string, src []byte) (string, string, bool) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+	if err != nil {
+		return "", "", false
+	}
+
+	directives := []string(nil)
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if isDirective(c.Text) {
+				directives = append(directives, c.Text)
+			}
+		}
+	}
+	for _, imp := range f.Imports {
+		if path, _ := strconv.Unquote(imp.Path.Value); path != "C" {
+			continue
+		}
+		if imp.Doc != nil {
+			directives = append(directives, imp.Doc.Text())
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if ok && gen.Tok == token.IMPORT && !gen.Lparen.IsValid() && gen.Specs[0] == imp && gen.Doc != nil {
+				directives = append(directives, gen.Doc.Text())
+			}
+		}
+	}
+
+	return impact.Tokens(src), strings.Join(directives, "\n"), true
+}
+
+// isDirective reports whether a comment affects the build.
+func isDirective(text string) bool {
+	for _, prefix := range []string{"//go:", "// +build", "//line ", "/*line ", "//export ", "//extern "} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// exampleOutputs returns the expected output of each example function in a
+// Go test file, which go test checks against what the example prints.
+func exampleOutputs(file string, src []byte) string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, src, parser.ParseComments)
+	if err != nil {
+		return ""
+	}
+	outputs := []string(nil)
+	for _, example := range doc.Examples(f) {
+		outputs = append(outputs, fmt.Sprintf("%s %t %t\n%s", example.Name, example.Unordered, example.EmptyOutput, example.Output))
+	}
+	return strings.Join(outputs, "\n")
+}
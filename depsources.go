@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// sameDepSources reports whether a package of a dependency module has the
+// same source and embedded files as in past, the module's base version,
+// found in the module cache, and the module's go version is unchanged. Test
+// files are not compared, as the tests of dependencies are not run. It is
+// false when the base version is not in the module cache.
+func sameDepSources(pkg packages.Package, past packages.Module) bool {
+	if past.Dir == "" || pkg.Module.Dir == "" || pkg.Dir == "" {
+		return false
+	}
+	if past.GoVersion != pkg.Module.GoVersion {
+		return false
+	}
+	rel, err := filepath.Rel(pkg.Module.Dir, pkg.Dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	return sameSources(pkg.Dir, filepath.Join(past.Dir, rel), pkg.EmbedPatterns)
+}
+
+// sameSources reports whether the directories dir and pastDir have the same
+// source files, other than tests, and the same files matched by the embed
+// patterns.
+func sameSources(dir, pastDir string, embedPatterns []string) bool {
+	files, err := sourceFiles(dir)
+	if err != nil {
+		return false
+	}
+	pastFiles, err := sourceFiles(pastDir)
+	if err != nil {
+		return false
+	}
+	files = append(files, embedFiles(dir, nil, embedPatterns)...)
+	pastFiles = append(pastFiles, embedFiles(pastDir, nil, embedPatterns)...)
+	if !sameSet(files, pastFiles) {
+		return false
+	}
+	for _, file := range files {
+		current, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return false
+		}
+		past, err := os.ReadFile(filepath.Join(pastDir, file))
+		if err != nil || !bytes.Equal(past, current) {
+			return false
+		}
+	}
+	return true
+}
+
+// sourceFiles returns the names of the files in dir that the go command may
+// build, other than tests, sorted.
+func sourceFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string(nil)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && sourceExts[filepath.Ext(name)] && !strings.HasSuffix(name, "_test.go") {
+			files = append(files, name)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
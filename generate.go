@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// generates reports whether a //go:generate directive in the Go files of
+// pkg, including its tests, runs tool, a package path, whether with
+// `go run`, with `go tool` or by the name of its binary.
+func generates(pkg packages.Package, tool string) bool {
+	name := toolName(tool)
+	for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+		for _, file := range files {
+			f, err := os.Open(filepath.Join(pkg.Dir, file))
+			if err != nil {
+				continue
+			}
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				command, ok := strings.CutPrefix(scanner.Text(), "//go:generate ")
+				if ok && runsTool(strings.Fields(command), tool, name) {
+					f.Close()
+					return true
+				}
+			}
+			f.Close()
+		}
+	}
+	return false
+}
+
+func runsTool(fields []string, tool, name string) bool {
+	for i, field := range fields {
+		if i == 0 && field == name {
+			return true
+		}
+		if field == tool || strings.HasPrefix(field, tool+"@") {
+			return true
+		}
+		if field == "tool" && i+1 < len(fields) && fields[i+1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// toolName returns the name `go tool` knows tool by, the last element of its
+// package path other than a major version suffix.
+func toolName(tool string) string {
+	name := path.Base(tool)
+	if dir := path.Dir(tool); dir != "." && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		return path.Base(dir)
+	}
+	return name
+}
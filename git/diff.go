@@ -11,10 +11,120 @@ import (
 	"github.com/juju/errors"
 )
 
-func DiffNames(dir, treeish string) ([]string, error) {
+// Source identifies where a change was found.
+type Source string
+
+const (
+	// SourceCommitted is a change committed between the base and HEAD (or
+	// the head of a commit range).
+	SourceCommitted Source = "committed"
+	// SourceStaged is a change staged in the index but not yet committed.
+	SourceStaged Source = "staged"
+	// SourceUnstaged is a change in the working tree not yet staged.
+	SourceUnstaged Source = "unstaged"
+	// SourceUntracked is a file in the working tree not known to git.
+	SourceUntracked Source = "untracked"
+)
+
+// Change is a single changed file.
+type Change struct {
+	Path   string
+	Source Source
+}
+
+// ChangeSet selects which sources of changes are considered.
+type ChangeSet struct {
+	Committed bool
+	Staged    bool
+	Unstaged  bool
+	Untracked bool
+}
+
+// DefaultChangeSet matches git-diff against the working tree.
+var DefaultChangeSet = ChangeSet{Committed: true, Staged: true, Unstaged: true}
+
+// ParseChangeSet parses a comma separated list of sources.
+func ParseChangeSet(s string) (ChangeSet, error) {
+	set := ChangeSet{}
+	for _, v := range strings.Split(s, ",") {
+		switch Source(strings.TrimSpace(v)) {
+		case SourceCommitted:
+			set.Committed = true
+		case SourceStaged:
+			set.Staged = true
+		case SourceUnstaged:
+			set.Unstaged = true
+		case SourceUntracked:
+			set.Untracked = true
+		default:
+			return ChangeSet{}, errors.NotValidf("change source %q", v)
+		}
+	}
+	return set, nil
+}
+
+// DiffNames returns the files changed in the range. Staged, unstaged and
+// untracked changes only apply when the head of the range is the working
+// tree.
+func DiffNames(dir string, r Range, set ChangeSet) ([]Change, error) {
+	changes := []Change(nil)
+	if set.Committed && r.Base != "" {
+		args := []string{r.Base}
+		if r.WorkingTree() {
+			args = append(args, "HEAD")
+		} else {
+			args = append(args, r.Head)
+		}
+		files, err := diffNames(dir, args...)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		changes = appendChanges(changes, files, SourceCommitted)
+	}
+	if !r.WorkingTree() {
+		return changes, nil
+	}
+	if set.Staged {
+		files, err := diffNames(dir, "--cached")
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		changes = appendChanges(changes, files, SourceStaged)
+	}
+	if set.Unstaged {
+		files, err := diffNames(dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		changes = appendChanges(changes, files, SourceUnstaged)
+	}
+	if set.Untracked {
+		files, err := untracked(dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		changes = appendChanges(changes, files, SourceUntracked)
+	}
+	return changes, nil
+}
+
+func appendChanges(changes []Change, files []string, source Source) []Change {
+	for _, file := range files {
+		changes = append(changes, Change{Path: file, Source: source})
+	}
+	return changes
+}
+
+func diffNames(dir string, revs ...string) ([]string, error) {
+	args := []string{"-C", dir, "diff", "--name-status"}
+	for _, rev := range revs {
+		if rev != "" {
+			args = append(args, rev)
+		}
+	}
 	stdout := &bytes.Buffer{}
 	stderr := &bytes.Buffer{}
-	cmd := exec.Command("git", "-C", dir, "diff", "--name-status", treeish, "--")
+	cmd := exec.Command("git", append(args, "--")...)
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	err := cmd.Run()
@@ -52,3 +162,23 @@ func DiffNames(dir, treeish string) ([]string, error) {
 	}
 	return files, nil
 }
+
+func untracked(dir string) ([]string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", "-C", dir, "ls-files", "--others", "--exclude-standard")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Annotate(err, stderr.String())
+	}
+	files := []string(nil)
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		files = append(files, path.Join(dir, line))
+	}
+	return files, nil
+}
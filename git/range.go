@@ -0,0 +1,85 @@
+package git
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Range is the pair of revisions being compared. An empty Head refers to
+// the working tree, or to the index when Index is set.
+type Range struct {
+	Base  string
+	Head  string
+	Index bool
+}
+
+// ParseRange resolves spec into a Range. A spec of the form "A..B" compares
+// the commits A and B, and "A...B" compares B with the merge-base of A and B.
+// A missing side of either form defaults to HEAD. Any other spec is a
+// treeish compared with the working tree; when mergeBase is set it is first
+// replaced with its merge-base with HEAD.
+func ParseRange(dir, spec string, mergeBase bool) (Range, error) {
+	if base, head, ok := strings.Cut(spec, "..."); ok {
+		base, head = defaultHead(base), defaultHead(head)
+		mb, err := MergeBase(dir, base, head)
+		if err != nil {
+			return Range{}, errors.Trace(err)
+		}
+		return Range{Base: mb, Head: head}, nil
+	}
+	if base, head, ok := strings.Cut(spec, ".."); ok {
+		return Range{Base: defaultHead(base), Head: defaultHead(head)}, nil
+	}
+	if mergeBase {
+		mb, err := MergeBase(dir, defaultHead(spec), "HEAD")
+		if err != nil {
+			return Range{}, errors.Trace(err)
+		}
+		return Range{Base: mb}, nil
+	}
+	return Range{Base: spec}, nil
+}
+
+// ForChanges returns the range holding only the changes in set. Its head
+// is the working tree when unstaged or untracked changes are considered,
+// otherwise the index when staged ones are, otherwise HEAD. Its base is
+// the state before the earliest of them: the base of r for committed
+// changes, otherwise HEAD, except for unstaged changes alone, whose base is
+// a commit of the index made in the repository at dir.
+func (r Range) ForChanges(dir string, set ChangeSet) (Range, error) {
+	if !r.WorkingTree() {
+		return r, nil
+	}
+	if !set.Committed {
+		r.Base = "HEAD"
+		if set.Unstaged && !set.Staged && !set.Untracked {
+			index, err := IndexCommit(dir)
+			if err != nil {
+				return Range{}, errors.Trace(err)
+			}
+			r.Base = index
+		}
+	}
+	if set.Unstaged || set.Untracked {
+		return r, nil
+	}
+	if set.Staged {
+		r.Index = true
+		return r, nil
+	}
+	r.Head = "HEAD"
+	return r, nil
+}
+
+// WorkingTree reports whether the head of the range is the working tree.
+func (r Range) WorkingTree() bool {
+	return r.Head == ""
+}
+
+func defaultHead(rev string) string {
+	if rev == "" {
+		return "HEAD"
+	}
+	return rev
+}
@@ -3,7 +3,9 @@ package git
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/juju/errors"
@@ -31,10 +33,90 @@ func Read(dir, treeish, file string) ([]byte, error) {
 	err := cmd.Run()
 	if err != nil {
 		errStr := stderr.String()
-		if strings.Contains(errStr, "does not exist in") {
+		if strings.Contains(errStr, "does not exist in") ||
+			strings.Contains(errStr, "exists on disk, but not in") ||
+			strings.Contains(errStr, "nor in the index") {
 			return nil, errors.NewNotFound(err, errStr)
 		}
 		return nil, errors.Annotate(err, errStr)
 	}
 	return stdout.Bytes(), nil
 }
+
+func MergeBase(dir, a, b string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", "-C", dir, "merge-base", a, b)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Annotate(err, stderr.String())
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// IndexCommit returns a commit of the index of the repository at dir, with
+// HEAD as its parent, so that it can be read and checked out like any
+// other revision. No ref points to the commit.
+func IndexCommit(dir string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", "-C", dir, "write-tree")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Annotate(err, stderr.String())
+	}
+	tree := strings.TrimRight(stdout.String(), "\n")
+	stdout.Reset()
+	stderr.Reset()
+	cmd = exec.Command("git", "-C", dir, "commit-tree", tree, "-p", "HEAD", "-m", "index")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// The commit is never shared, so it needs no configured identity.
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=gochanged", "GIT_AUTHOR_EMAIL=gochanged",
+		"GIT_COMMITTER_NAME=gochanged", "GIT_COMMITTER_EMAIL=gochanged")
+	err = cmd.Run()
+	if err != nil {
+		return "", errors.Annotate(err, stderr.String())
+	}
+	return strings.TrimRight(stdout.String(), "\n"), nil
+}
+
+// AddWorktree checks out rev, detached, in a new temporary worktree of the
+// repository at dir, returning its path.
+func AddWorktree(dir, rev string) (string, error) {
+	path, err := os.MkdirTemp("", "gochanged-")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", "-C", dir, "worktree", "add", "--quiet", "--detach", path, rev)
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		os.RemoveAll(path)
+		return "", errors.Annotate(err, stderr.String())
+	}
+	// The go command reports directories with symlinks resolved.
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path, nil
+	}
+	return resolved, nil
+}
+
+// RemoveWorktree removes a worktree added by AddWorktree.
+func RemoveWorktree(dir, path string) error {
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("git", "-C", dir, "worktree", "remove", "--force", path)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if err != nil {
+		return errors.Annotate(err, stderr.String())
+	}
+	return nil
+}
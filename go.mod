@@ -1,10 +1,11 @@
 module github.com/hpidcock/gochanged
 
-go 1.20
+go 1.22.0
 
 require (
 	github.com/dominikbraun/graph v0.16.2
-	github.com/juju/collections v1.0.4
 	github.com/juju/errors v1.0.0
-	golang.org/x/mod v0.17.0
+	golang.org/x/mod v0.22.0
 )
+
+require github.com/kr/pretty v0.3.1 // indirect
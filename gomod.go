@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"golang.org/x/mod/modfile"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/gomod"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// readHead reads file, an absolute path under gitRoot, at the head of rng.
+func readHead(gitRoot string, rng git.Range, file string) ([]byte, error) {
+	if rng.Index {
+		// An empty treeish reads the index.
+		return git.Read(gitRoot, "", gitPath(gitRoot, file))
+	}
+	if rng.WorkingTree() {
+		return ioutil.ReadFile(file)
+	}
+	return git.Read(gitRoot, rng.Head, gitPath(gitRoot, file))
+}
+
+// readBase reads file, an absolute path under gitRoot, at the base of rng,
+// which is HEAD when the range has no base.
+func readBase(gitRoot string, rng git.Range, file string) ([]byte, error) {
+	base := rng.Base
+	if base == "" {
+		base = "HEAD"
+	}
+	return git.Read(gitRoot, base, gitPath(gitRoot, file))
+}
+
+// gitPath returns file relative to gitRoot, as used in treeish paths.
+func gitPath(gitRoot, file string) string {
+	return strings.TrimLeft(strings.TrimPrefix(file, gitRoot), string(filepath.Separator))
+}
+
+// modChanges collects the changes to the go.mod and go.work files.
+type modChanges struct {
+	// modules maps module paths to the reasons all of their packages
+	// changed.
+	modules map[string][]Reason
+	// past are the base build list entries of modules whose selected
+	// version changed.
+	past map[string]packages.Module
+	// tools maps module paths, then the package paths of their tools, to
+	// the reasons the packages running the tool with //go:generate changed.
+	tools map[string]map[string][]Reason
+	// toolchains maps module paths to the changes of their go and
+	// toolchain directives.
+	toolchains map[string]toolchainChange
+	// ignored are the changes that affect no packages.
+	ignored []ignoredChange
+	// offline loads the build lists from the module cache only.
+	offline bool
+}
+
+// toolchainChange is a change to the go or toolchain directives of a go.mod
+// file, which select the language version and the toolchain.
+type toolchainChange struct {
+	file              string
+	pastGo, goVersion string
+	pastName, name    string
+
+	goEvent, toolchainEvent *gomod.Event
+}
+
+// selecting returns the event of the directive selecting the toolchain, the
+// toolchain directive if it changed.
+func (c toolchainChange) selecting() *gomod.Event {
+	if c.toolchainEvent != nil {
+		return c.toolchainEvent
+	}
+	return c.goEvent
+}
+
+func newModChanges() *modChanges {
+	return &modChanges{
+		modules:    make(map[string][]Reason),
+		past:       make(map[string]packages.Module),
+		tools:      make(map[string]map[string][]Reason),
+		toolchains: make(map[string]toolchainChange),
+	}
+}
+
+// compareModFile compares the go.mod file between the base and head of rng,
+// directive by directive, recording each change in changes according to
+// the policy of its directive. Changed requirements and exclusions are
+// superseded by comparing the build lists, when they can be loaded.
+func compareModFile(gitRoot string, rng git.Range, file string, changes *modChanges) error {
+	if !packages.Within(file, gitRoot) {
+		return errors.Errorf("%s is not under git root %s", file, gitRoot)
+	}
+	subpath := gitPath(gitRoot, file)
+
+	currentModFile, err := readHead(gitRoot, rng, file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	currentMod, err := modfile.Parse(subpath, currentModFile, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	modPath := currentMod.Module.Mod.Path
+
+	pastModFile, err := readBase(gitRoot, rng, file)
+	if errors.Is(err, errors.NotFound) {
+		changes.modules[modPath] = append(changes.modules[modPath], Reason{Code: ReasonNewGoMod, Path: modPath})
+		return nil
+	} else if err != nil {
+		return errors.Trace(err)
+	}
+	pastMod, err := modfile.Parse(subpath, pastModFile, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if bytes.Equal(pastModFile, currentModFile) {
+		return nil
+	}
+
+	events := gomod.Diff(pastMod, currentMod)
+	var marked map[string]bool
+	var buildListsErr error
+	if selectsModules(events) {
+		marked, buildListsErr = compareBuildLists(gitRoot, rng, filepath.Dir(file), changes.offline, events, changes.modules, changes.past)
+		if buildListsErr != nil {
+			reason := "build lists not loaded, comparing requirements instead: " + oneLine(buildListsErr.Error())
+			changes.ignored = append(changes.ignored, ignoredChange{Change: git.Change{Path: file}, Reason: reason})
+		}
+	}
+	for _, event := range events {
+		switch event.Policy() {
+		case gomod.AffectsAll:
+			changes.modules[modPath] = append(changes.modules[modPath], eventReason(modPath, event))
+		case gomod.AffectsToolchain:
+			change := changes.toolchains[modPath]
+			change.file = file
+			change.pastGo, change.goVersion = goVersion(pastMod.Go), goVersion(currentMod.Go)
+			change.pastName, change.name = toolchainName(pastMod.Toolchain), toolchainName(currentMod.Toolchain)
+			if event.Directive == gomod.Go {
+				change.goEvent = &event
+			} else {
+				change.toolchainEvent = &event
+			}
+			changes.toolchains[modPath] = change
+		case gomod.AffectsModule:
+			if buildListsErr == nil && event.Directive != gomod.Replace {
+				if !marked[event.Path] {
+					reason := fmt.Sprintf("%s %s, selects no other version", event.Directive, event)
+					changes.ignored = append(changes.ignored, ignoredChange{Change: git.Change{Path: file}, Reason: reason, Event: &event})
+				}
+				continue
+			}
+			// The build lists cannot be loaded, for example when
+			// offline, so the requirements and exclusions are used
+			// instead.
+			path := strings.Fields(event.Path)[0]
+			changes.modules[path] = append(changes.modules[path], eventReason(path, event))
+		case gomod.AffectsGenerate:
+			if changes.tools[modPath] == nil {
+				changes.tools[modPath] = make(map[string][]Reason)
+			}
+			changes.tools[modPath][event.Path] = append(changes.tools[modPath][event.Path], eventReason(event.Path, event))
+		default:
+			reason := fmt.Sprintf("%s %s, affects no packages", event.Directive, event)
+			changes.ignored = append(changes.ignored, ignoredChange{Change: git.Change{Path: file}, Reason: reason, Event: &event})
+		}
+	}
+	return nil
+}
+
+// selectsModules reports whether any of events may change the versions of
+// the modules selected for the build.
+func selectsModules(events []gomod.Event) bool {
+	for _, event := range events {
+		switch event.Directive {
+		case gomod.Require, gomod.Exclude, gomod.Replace:
+			return true
+		}
+	}
+	return false
+}
+
+// eventReasons are the reason codes of the events of each directive, by
+// how it changed.
+var eventReasons = map[gomod.Directive]map[gomod.Op]ReasonCode{
+	gomod.Godebug: {gomod.Added: ReasonGodebugChanged, gomod.Removed: ReasonGodebugChanged, gomod.Changed: ReasonGodebugChanged},
+	gomod.Require: {gomod.Added: ReasonNewDep, gomod.Removed: ReasonRemovedDep, gomod.Changed: ReasonChangedDep},
+	gomod.Exclude: {gomod.Added: ReasonExcludeChanged, gomod.Removed: ReasonExcludeChanged, gomod.Changed: ReasonExcludeChanged},
+	gomod.Replace: {gomod.Added: ReasonNewReplace, gomod.Removed: ReasonRemovedReplace, gomod.Changed: ReasonChangedReplace},
+	gomod.Tool:    {gomod.Added: ReasonToolChanged, gomod.Removed: ReasonToolChanged, gomod.Changed: ReasonToolChanged},
+}
+
+// eventReason returns the reason for the packages at path changing by
+// event, which the reason carries.
+func eventReason(path string, event gomod.Event) Reason {
+	detail := event.String()
+	if path == event.Path || strings.HasPrefix(event.Path, path+" ") {
+		detail = strings.TrimSpace(strings.TrimPrefix(event.Path, path) + " " + event.Values())
+	}
+	return Reason{Code: eventReasons[event.Directive][event.Op], Path: path, Detail: detail, Event: &event}
+}
+
+// compareBuildLists marks the modules newly selected for the build of the
+// module in dir, or whose selected version changed, between the base and
+// head of rng, recording the base entries of the latter in pastChanged. The
+// reasons carry the require or exclude event of the module among events, if
+// any. It returns the module paths marked.
+func compareBuildLists(gitRoot string, rng git.Range, dir string, offline bool, events []gomod.Event, changed map[string][]Reason, pastChanged map[string]packages.Module) (map[string]bool, error) {
+	lists, err := loadBuildLists(gitRoot, rng, dir, offline)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	moduleEvents := map[string]*gomod.Event{}
+	for i, event := range events {
+		if event.Directive == gomod.Require || (event.Directive == gomod.Exclude && moduleEvents[event.Path] == nil) {
+			moduleEvents[event.Path] = &events[i]
+		}
+	}
+	marked := map[string]bool{}
+	for modPath, m := range lists.current {
+		if m.Main {
+			continue
+		}
+		version := moduleVersion(m)
+		detail := version
+		if pastModule, ok := lists.past[modPath]; !ok {
+			detail += requiredBy(lists.requirers[modPath+"@"+m.Version])
+			changed[modPath] = append(changed[modPath], Reason{Code: ReasonNewDep, Path: modPath, Detail: detail, Event: moduleEvents[modPath]})
+			marked[modPath] = true
+		} else if pastVersion := moduleVersion(pastModule); pastVersion != version {
+			detail = pastVersion + " => " + version + requiredBy(lists.requirers[modPath+"@"+m.Version])
+			changed[modPath] = append(changed[modPath], Reason{Code: ReasonChangedDep, Path: modPath, Detail: detail, Event: moduleEvents[modPath]})
+			marked[modPath] = true
+			pastChanged[modPath] = pastModule
+		}
+	}
+	return marked, nil
+}
+
+// buildLists are the build lists of a module at the base and head of a
+// range, and the requirers of each module version at head.
+type buildLists struct {
+	past, current map[string]packages.Module
+	requirers     map[string][]string
+	err           error
+}
+
+type buildListsKey struct {
+	gitRoot string
+	rng     git.Range
+	dir     string
+	offline bool
+}
+
+// loadedBuildLists are the build lists loaded so far, which every platform,
+// matrix entry and integration run compares.
+var loadedBuildLists = map[buildListsKey]*buildLists{}
+
+// loadBuildLists loads the build lists of the module in dir at the base and
+// head of rng, or returns those already loaded. The base, and the head
+// unless it is the working tree, are checked out in temporary git
+// worktrees. When offline, the go command only reads the module cache.
+func loadBuildLists(gitRoot string, rng git.Range, dir string, offline bool) (*buildLists, error) {
+	key := buildListsKey{gitRoot: gitRoot, rng: rng, dir: dir, offline: offline}
+	if lists, ok := loadedBuildLists[key]; ok {
+		return lists, errors.Trace(lists.err)
+	}
+	lists := &buildLists{}
+	lists.err = func() error {
+		rel := gitPath(gitRoot, dir)
+		base := rng.Base
+		if base == "" {
+			base = "HEAD"
+		}
+		err := atRevision(gitRoot, base, rel, func(dir string) (err error) {
+			lists.past, err = packages.BuildList(dir, offline)
+			return errors.Trace(err)
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		loadHead := func(dir string) (err error) {
+			if lists.current, err = packages.BuildList(dir, offline); err != nil {
+				return errors.Trace(err)
+			}
+			lists.requirers, err = packages.Requirers(dir, offline)
+			return errors.Trace(err)
+		}
+		if rng.WorkingTree() {
+			return errors.Trace(loadHead(dir))
+		}
+		return errors.Trace(atRevision(gitRoot, rng.Head, rel, loadHead))
+	}()
+	loadedBuildLists[key] = lists
+	return lists, errors.Trace(lists.err)
+}
+
+// atRevision calls f with the directory rel, relative to the git root, in a
+// temporary git worktree of rev.
+func atRevision(gitRoot, rev, rel string, f func(dir string) error) error {
+	worktree, err := git.AddWorktree(gitRoot, rev)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer git.RemoveWorktree(gitRoot, worktree)
+	return errors.Trace(f(filepath.Join(worktree, rel)))
+}
+
+// moduleVersion returns the version of a module in the build list, along
+// with its replacement.
+func moduleVersion(m packages.Module) string {
+	if m.Replace == nil {
+		return m.Version
+	}
+	return strings.TrimSpace(m.Version + " => " + m.Replace.Path + " " + m.Replace.Version)
+}
+
+// requiredBy describes the modules requiring a module version.
+func requiredBy(requirers []string) string {
+	if len(requirers) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), requirers...)
+	sort.Strings(sorted)
+	return ", required by " + strings.Join(sorted, ", ")
+}
+
+func goVersion(g *modfile.Go) string {
+	if g == nil {
+		return ""
+	}
+	return g.Version
+}
+
+func toolchainName(t *modfile.Toolchain) string {
+	if t == nil {
+		return ""
+	}
+	return t.Name
+}
+
+// compareReplaces marks new, changed and removed replacements as changed.
+func compareReplaces(past, current []*modfile.Replace, changed map[string][]Reason) {
+	pastReplace := map[string]*modfile.Replace{}
+	for _, rep := range past {
+		pastReplace[rep.Old.Path] = rep
+	}
+	for _, rep := range current {
+		importPath := rep.Old.Path
+		pastRep, ok := pastReplace[importPath]
+		if !ok {
+			changed[importPath] = append(changed[importPath], Reason{Code: ReasonNewReplace, Path: importPath})
+			continue
+		}
+		delete(pastReplace, importPath)
+		if rep.Old.Version != pastRep.Old.Version ||
+			rep.New.Path != pastRep.New.Path ||
+			rep.New.Version != pastRep.New.Version {
+			changed[importPath] = append(changed[importPath], Reason{Code: ReasonChangedReplace, Path: importPath})
+			continue
+		}
+	}
+	for importPath := range pastReplace {
+		changed[importPath] = append(changed[importPath], Reason{Code: ReasonRemovedReplace, Path: importPath})
+	}
+}
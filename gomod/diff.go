@@ -0,0 +1,245 @@
+// Package gomod compares go.mod files directive by directive.
+package gomod
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Directive is a go.mod directive, or the // indirect marker of a
+// requirement.
+type Directive string
+
+const (
+	Go        Directive = "go"
+	Toolchain Directive = "toolchain"
+	Godebug   Directive = "godebug"
+	Require   Directive = "require"
+	Indirect  Directive = "indirect"
+	Exclude   Directive = "exclude"
+	Replace   Directive = "replace"
+	Retract   Directive = "retract"
+	Tool      Directive = "tool"
+)
+
+// Policy is which packages a change to a directive affects.
+type Policy string
+
+const (
+	// AffectsNothing changes select no packages.
+	AffectsNothing Policy = "nothing"
+	// AffectsAll changes affect every package built with the go.mod file.
+	AffectsAll Policy = "all"
+	// AffectsToolchain changes affect the packages whose meaning changes
+	// with the language version, and those of the standard library that
+	// differ between the toolchains selected before and after.
+	AffectsToolchain Policy = "toolchain"
+	// AffectsModule changes affect the packages of the module at the
+	// event's Path.
+	AffectsModule Policy = "module"
+	// AffectsGenerate changes affect the packages whose //go:generate
+	// directives run the tool at the event's Path.
+	AffectsGenerate Policy = "generate"
+)
+
+// Policies are the propagation policy of each directive. Retractions only
+// concern users of the module, and the // indirect marker only documents a
+// requirement.
+var Policies = map[Directive]Policy{
+	Go:        AffectsToolchain,
+	Toolchain: AffectsToolchain,
+	Godebug:   AffectsAll,
+	Require:   AffectsModule,
+	Indirect:  AffectsNothing,
+	Exclude:   AffectsModule,
+	Replace:   AffectsModule,
+	Retract:   AffectsNothing,
+	Tool:      AffectsGenerate,
+}
+
+// Op is how a directive changed.
+type Op string
+
+const (
+	Added   Op = "added"
+	Removed Op = "removed"
+	Changed Op = "changed"
+)
+
+// Event is a change to one directive. Path identifies the directive among
+// others of its kind: the module path of require, indirect, exclude and
+// replace, the key of godebug, the package path of tool and the version
+// interval of retract. Old and New are its values before and after, such as
+// versions, empty when it was added or removed.
+type Event struct {
+	Directive Directive
+	Op        Op
+	Path      string `json:",omitempty"`
+	Old       string `json:",omitempty"`
+	New       string `json:",omitempty"`
+}
+
+// Policy returns the propagation policy of the event's directive.
+func (e Event) Policy() Policy {
+	return Policies[e.Directive]
+}
+
+// String describes the change without its directive, such as
+// "example.com/dep v1.0.0 => v1.1.0".
+func (e Event) String() string {
+	return strings.TrimSpace(e.Path + " " + e.Values())
+}
+
+// Values describes the change of the directive's values, such as
+// "v1.0.0 => v1.1.0".
+func (e Event) Values() string {
+	s := ""
+	switch e.Op {
+	case Added:
+		s = e.New + " added"
+	case Removed:
+		s = e.Old + " removed"
+	default:
+		s = e.Old + " => " + e.New
+	}
+	return strings.TrimSpace(s)
+}
+
+// Diff returns the changes from past to current, grouped by directive and
+// sorted by path.
+func Diff(past, current *modfile.File) []Event {
+	events := []Event(nil)
+	add := func(directive Directive, past, current map[string]string) {
+		paths := []string(nil)
+		for path := range current {
+			paths = append(paths, path)
+		}
+		for path := range past {
+			if _, ok := current[path]; !ok {
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			old, inPast := past[path]
+			new, inCurrent := current[path]
+			switch {
+			case !inPast:
+				events = append(events, Event{Directive: directive, Op: Added, Path: path, New: new})
+			case !inCurrent:
+				events = append(events, Event{Directive: directive, Op: Removed, Path: path, Old: old})
+			case old != new:
+				events = append(events, Event{Directive: directive, Op: Changed, Path: path, Old: old, New: new})
+			}
+		}
+	}
+
+	add(Go, goVersion(past), goVersion(current))
+	add(Toolchain, toolchain(past), toolchain(current))
+	add(Godebug, godebugs(past), godebugs(current))
+	pastRequires, pastIndirect := requires(past)
+	currentRequires, currentIndirect := requires(current)
+	add(Require, pastRequires, currentRequires)
+	// The marker only changes for requirements present in both.
+	for path := range pastIndirect {
+		if _, ok := currentIndirect[path]; !ok {
+			delete(pastIndirect, path)
+		}
+	}
+	for path := range currentIndirect {
+		if _, ok := pastIndirect[path]; !ok {
+			delete(currentIndirect, path)
+		}
+	}
+	add(Indirect, pastIndirect, currentIndirect)
+	add(Exclude, excludes(past), excludes(current))
+	add(Replace, replaces(past), replaces(current))
+	add(Retract, retracts(past), retracts(current))
+	add(Tool, tools(past), tools(current))
+	return events
+}
+
+func goVersion(f *modfile.File) map[string]string {
+	if f.Go == nil {
+		return nil
+	}
+	return map[string]string{"": f.Go.Version}
+}
+
+func toolchain(f *modfile.File) map[string]string {
+	if f.Toolchain == nil {
+		return nil
+	}
+	return map[string]string{"": f.Toolchain.Name}
+}
+
+func godebugs(f *modfile.File) map[string]string {
+	values := map[string]string{}
+	for _, g := range f.Godebug {
+		values[g.Key] = g.Value
+	}
+	return values
+}
+
+// requires returns the version of each requirement, and whether it is
+// marked // indirect.
+func requires(f *modfile.File) (map[string]string, map[string]string) {
+	versions := map[string]string{}
+	indirect := map[string]string{}
+	for _, r := range f.Require {
+		versions[r.Mod.Path] = r.Mod.Version
+		indirect[r.Mod.Path] = "direct"
+		if r.Indirect {
+			indirect[r.Mod.Path] = "indirect"
+		}
+	}
+	return versions, indirect
+}
+
+// excludes returns the excluded versions of each module path, space
+// separated.
+func excludes(f *modfile.File) map[string]string {
+	versions := map[string][]string{}
+	for _, e := range f.Exclude {
+		versions[e.Mod.Path] = append(versions[e.Mod.Path], e.Mod.Version)
+	}
+	values := map[string]string{}
+	for path, v := range versions {
+		sort.Strings(v)
+		values[path] = strings.Join(v, " ")
+	}
+	return values
+}
+
+// replaces returns the replacement of each replaced module path, and
+// version if only one version is replaced.
+func replaces(f *modfile.File) map[string]string {
+	values := map[string]string{}
+	for _, r := range f.Replace {
+		values[strings.TrimSpace(r.Old.Path+" "+r.Old.Version)] = strings.TrimSpace(r.New.Path + " " + r.New.Version)
+	}
+	return values
+}
+
+// retracts returns the rationale of each retracted version interval.
+func retracts(f *modfile.File) map[string]string {
+	values := map[string]string{}
+	for _, r := range f.Retract {
+		interval := r.Low
+		if r.High != r.Low {
+			interval = "[" + r.Low + ", " + r.High + "]"
+		}
+		values[interval] = r.Rationale
+	}
+	return values
+}
+
+func tools(f *modfile.File) map[string]string {
+	values := map[string]string{}
+	for _, t := range f.Tool {
+		values[t.Path] = ""
+	}
+	return values
+}
@@ -0,0 +1,177 @@
+package gomod
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		past, current string
+		want          []Event
+	}{{
+		name:    "unchanged",
+		past:    "go 1.21\nrequire example.com/a v1.0.0\n",
+		current: "go 1.21\n\nrequire (\n\texample.com/a v1.0.0\n)\n",
+	}, {
+		name:    "go changed",
+		past:    "go 1.21\n",
+		current: "go 1.22\n",
+		want:    []Event{{Directive: Go, Op: Changed, Old: "1.21", New: "1.22"}},
+	}, {
+		name:    "go added",
+		current: "go 1.22\n",
+		want:    []Event{{Directive: Go, Op: Added, New: "1.22"}},
+	}, {
+		name:    "toolchain added",
+		past:    "go 1.21\n",
+		current: "go 1.21\ntoolchain go1.22.0\n",
+		want:    []Event{{Directive: Toolchain, Op: Added, New: "go1.22.0"}},
+	}, {
+		name:    "toolchain removed",
+		past:    "go 1.21\ntoolchain go1.22.0\n",
+		current: "go 1.21\n",
+		want:    []Event{{Directive: Toolchain, Op: Removed, Old: "go1.22.0"}},
+	}, {
+		name:    "godebug",
+		past:    "godebug panicnil=1\ngodebug httpmuxgo121=1\n",
+		current: "godebug panicnil=0\ngodebug tlsrsakex=1\n",
+		want: []Event{
+			{Directive: Godebug, Op: Removed, Path: "httpmuxgo121", Old: "1"},
+			{Directive: Godebug, Op: Changed, Path: "panicnil", Old: "1", New: "0"},
+			{Directive: Godebug, Op: Added, Path: "tlsrsakex", New: "1"},
+		},
+	}, {
+		name:    "require",
+		past:    "require example.com/a v1.0.0\nrequire example.com/b v1.0.0\n",
+		current: "require example.com/a v1.1.0\nrequire example.com/c v1.0.0\n",
+		want: []Event{
+			{Directive: Require, Op: Changed, Path: "example.com/a", Old: "v1.0.0", New: "v1.1.0"},
+			{Directive: Require, Op: Removed, Path: "example.com/b", Old: "v1.0.0"},
+			{Directive: Require, Op: Added, Path: "example.com/c", New: "v1.0.0"},
+		},
+	}, {
+		name:    "indirect marker",
+		past:    "require example.com/a v1.0.0\nrequire example.com/b v1.0.0 // indirect\n",
+		current: "require example.com/a v1.0.0 // indirect\nrequire example.com/b v1.0.0\n",
+		want: []Event{
+			{Directive: Indirect, Op: Changed, Path: "example.com/a", Old: "direct", New: "indirect"},
+			{Directive: Indirect, Op: Changed, Path: "example.com/b", Old: "indirect", New: "direct"},
+		},
+	}, {
+		name:    "indirect requirement added or removed",
+		past:    "require example.com/a v1.0.0 // indirect\n",
+		current: "require example.com/b v1.0.0 // indirect\n",
+		want: []Event{
+			{Directive: Require, Op: Removed, Path: "example.com/a", Old: "v1.0.0"},
+			{Directive: Require, Op: Added, Path: "example.com/b", New: "v1.0.0"},
+		},
+	}, {
+		name:    "exclude",
+		past:    "exclude example.com/a v1.0.0\nexclude example.com/b v1.0.0\n",
+		current: "exclude example.com/a v1.0.0\nexclude example.com/a v1.1.0\nexclude example.com/c v1.0.0\n",
+		want: []Event{
+			{Directive: Exclude, Op: Changed, Path: "example.com/a", Old: "v1.0.0", New: "v1.0.0 v1.1.0"},
+			{Directive: Exclude, Op: Removed, Path: "example.com/b", Old: "v1.0.0"},
+			{Directive: Exclude, Op: Added, Path: "example.com/c", New: "v1.0.0"},
+		},
+	}, {
+		name:    "replace",
+		past:    "replace example.com/a => ../a\nreplace example.com/b v1.0.0 => example.com/b2 v1.0.0\n",
+		current: "replace example.com/a => ../a2\nreplace example.com/c => ../c\n",
+		want: []Event{
+			{Directive: Replace, Op: Changed, Path: "example.com/a", Old: "../a", New: "../a2"},
+			{Directive: Replace, Op: Removed, Path: "example.com/b v1.0.0", Old: "example.com/b2 v1.0.0"},
+			{Directive: Replace, Op: Added, Path: "example.com/c", New: "../c"},
+		},
+	}, {
+		name:    "retract",
+		past:    "retract v1.0.0 // broken\nretract [v1.1.0, v1.1.2]\n",
+		current: "retract v1.0.0 // very broken\nretract v1.2.0\n",
+		want: []Event{
+			{Directive: Retract, Op: Removed, Path: "[v1.1.0, v1.1.2]"},
+			{Directive: Retract, Op: Changed, Path: "v1.0.0", Old: "broken", New: "very broken"},
+			{Directive: Retract, Op: Added, Path: "v1.2.0"},
+		},
+	}, {
+		name:    "tool",
+		past:    "tool example.com/a/cmd/a\n",
+		current: "tool example.com/b/cmd/b\n",
+		want: []Event{
+			{Directive: Tool, Op: Removed, Path: "example.com/a/cmd/a"},
+			{Directive: Tool, Op: Added, Path: "example.com/b/cmd/b"},
+		},
+	}, {
+		name:    "grouped by directive",
+		past:    "go 1.21\nrequire example.com/a v1.0.0\n",
+		current: "go 1.22\nrequire example.com/a v1.1.0\ntool example.com/a/cmd/a\ngodebug panicnil=1\n",
+		want: []Event{
+			{Directive: Go, Op: Changed, Old: "1.21", New: "1.22"},
+			{Directive: Godebug, Op: Added, Path: "panicnil", New: "1"},
+			{Directive: Require, Op: Changed, Path: "example.com/a", Old: "v1.0.0", New: "v1.1.0"},
+			{Directive: Tool, Op: Added, Path: "example.com/a/cmd/a"},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			past := parse(t, test.past)
+			current := parse(t, test.current)
+			if got := Diff(past, current); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	for _, directive := range []Directive{Go, Toolchain, Godebug, Require, Indirect, Exclude, Replace, Retract, Tool} {
+		if _, ok := Policies[directive]; !ok {
+			t.Errorf("no policy for %s", directive)
+		}
+	}
+	tests := []struct {
+		event Event
+		want  Policy
+	}{
+		{Event{Directive: Go}, AffectsToolchain},
+		{Event{Directive: Godebug}, AffectsAll},
+		{Event{Directive: Replace}, AffectsModule},
+		{Event{Directive: Retract}, AffectsNothing},
+		{Event{Directive: Indirect}, AffectsNothing},
+		{Event{Directive: Tool}, AffectsGenerate},
+	}
+	for _, test := range tests {
+		if got := test.event.Policy(); got != test.want {
+			t.Errorf("%s policy = %s, want %s", test.event.Directive, got, test.want)
+		}
+	}
+}
+
+func TestEventString(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Directive: Require, Op: Changed, Path: "example.com/a", Old: "v1.0.0", New: "v1.1.0"}, "example.com/a v1.0.0 => v1.1.0"},
+		{Event{Directive: Godebug, Op: Added, Path: "panicnil", New: "1"}, "panicnil 1 added"},
+		{Event{Directive: Tool, Op: Removed, Path: "example.com/a/cmd/a"}, "example.com/a/cmd/a removed"},
+		{Event{Directive: Go, Op: Changed, Old: "1.21", New: "1.22"}, "1.21 => 1.22"},
+	}
+	for _, test := range tests {
+		if got := test.event.String(); got != test.want {
+			t.Errorf("String() = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func parse(t *testing.T, src string) *modfile.File {
+	t.Helper()
+	f, err := modfile.Parse("go.mod", []byte("module example.com/m\n"+src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
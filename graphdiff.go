@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// GraphDiff is how the import graph of the repository's packages changed
+// between the base and head, as emitted by graphdiff --json.
+type GraphDiff struct {
+	AddedPackages      []string    `json:",omitempty"`
+	RemovedPackages    []string    `json:",omitempty"`
+	AddedImports       []Edge      `json:",omitempty"`
+	RemovedImports     []Edge      `json:",omitempty"`
+	AddedTestImports   []Edge      `json:",omitempty"` // imported only by the package's tests
+	RemovedTestImports []Edge      `json:",omitempty"`
+	AddedModules       []ModuleUse `json:",omitempty"` // third-party modules new to the graph
+	RemovedModules     []ModuleUse `json:",omitempty"`
+}
+
+// Edge is an import of To by From.
+type Edge struct {
+	From string
+	To   string
+}
+
+// ModuleUse is a third-party module and the packages of the repository
+// importing its packages, at head for added modules and at the base for
+// removed ones.
+type ModuleUse struct {
+	Path       string
+	Version    string   `json:",omitempty"`
+	ImportedBy []string `json:",omitempty"`
+}
+
+// importGraph is the import graph of the packages loaded at one revision, where
+// the repository's packages are those within root, outside vendor directories.
+type importGraph struct {
+	root string
+	pkgs map[string]packages.Package
+}
+
+// graphDiff runs the graphdiff subcommand, which reports how the import
+// graph of the packages matching the patterns changed between the base and
+// head.
+func graphDiff(args []string) error {
+	fs := flag.NewFlagSet("graphdiff", flag.ExitOnError)
+	treeish := fs.String("branch", "", "git branch, treeish or commit range (A..B or A...B) to diff against")
+	mergeBase := fs.Bool("merge-base", false, "diff against the merge-base of the branch and HEAD")
+	jsonOutput := fs.Bool("json", false, "print the differences as a JSON object")
+	goos := fs.String("goos", "", "GOOS to load packages for")
+	goarch := fs.String("goarch", "", "GOARCH to load packages for")
+	tags := fs.String("tags", "", "comma separated build tags to load packages with")
+	fs.Parse(args)
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"./..."}
+	}
+
+	buildCtx := build.Default
+	if *goos != "" {
+		buildCtx.GOOS = *goos
+	}
+	if *goarch != "" {
+		buildCtx.GOARCH = *goarch
+	}
+	if *tags != "" {
+		buildCtx.BuildTags = strings.Split(*tags, ",")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	gitRoot, err := git.Root(wd)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	rng, err := git.ParseRange(gitRoot, *treeish, *mergeBase)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	opts := &options{
+		wd:       wd,
+		gitRoot:  gitRoot,
+		rng:      rng,
+		patterns: patterns,
+	}
+
+	basePkgs, baseRoot, err := loadBase(opts, buildCtx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var headPkgs []packages.Package
+	headRoot := gitRoot
+	if rng.WorkingTree() {
+		headPkgs, err = loadPackages(buildCtx, wd, patterns)
+	} else {
+		headPkgs, headRoot, err = loadRevision(opts, buildCtx, rng.Head)
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	diff := diffGraphs(newImportGraph(basePkgs, baseRoot), newImportGraph(headPkgs, headRoot))
+	if *jsonOutput {
+		b, err := json.MarshalIndent(diff, "", "\t")
+		if err != nil {
+			return errors.Trace(err)
+		}
+		_, err = fmt.Fprintf(os.Stdout, "%s\n", b)
+		return errors.Trace(err)
+	}
+	return errors.Trace(diff.write(os.Stdout))
+}
+
+func newImportGraph(pkgs []packages.Package, root string) *importGraph {
+	g := &importGraph{
+		root: root,
+		pkgs: map[string]packages.Package{},
+	}
+	for _, pkg := range pkgs {
+		g.pkgs[pkg.ImportPath] = pkg
+	}
+	return g
+}
+
+// local returns the repository's packages, other than those it vendors.
+func (g *importGraph) local() map[string]packages.Package {
+	local := map[string]packages.Package{}
+	for importPath, pkg := range g.pkgs {
+		if g.isLocal(pkg) && hasFiles(pkg) {
+			local[importPath] = pkg
+		}
+	}
+	return local
+}
+
+// edges returns the imports of the repository's packages, and separately
+// those only imported by their tests.
+func (g *importGraph) edges() (map[Edge]bool, map[Edge]bool) {
+	imports := map[Edge]bool{}
+	testImports := map[Edge]bool{}
+	for importPath, pkg := range g.local() {
+		for _, imp := range pkg.Imports {
+			imports[Edge{From: importPath, To: imp}] = true
+		}
+		for _, list := range [][]string{pkg.TestImports, pkg.XTestImports} {
+			for _, imp := range list {
+				if imp != importPath && !contains(pkg.Imports, imp) {
+					testImports[Edge{From: importPath, To: imp}] = true
+				}
+			}
+		}
+	}
+	return imports, testImports
+}
+
+// isLocal reports whether pkg is one of the repository's own packages,
+// rather than a standard, third-party or vendored one.
+func (g *importGraph) isLocal(pkg packages.Package) bool {
+	return packages.Within(pkg.Dir, g.root) && !inVendor(pkg.Dir, g.root)
+}
+
+// modules returns the third-party modules of the packages in the graph,
+// including vendored ones.
+func (g *importGraph) modules() map[string]ModuleUse {
+	modules := map[string]ModuleUse{}
+	for _, pkg := range g.pkgs {
+		if pkg.Standard || pkg.Module.Path == "" || g.isLocal(pkg) {
+			continue
+		}
+		modules[pkg.Module.Path] = ModuleUse{Path: pkg.Module.Path, Version: pkg.Module.Version}
+	}
+	return modules
+}
+
+// importers returns the repository's packages importing any package of
+// the module, including from their tests.
+func (g *importGraph) importers(modulePath string) []string {
+	importers := []string(nil)
+	for importPath, pkg := range g.local() {
+	imports:
+		for _, list := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+			for _, imp := range list {
+				if dep, ok := g.pkgs[imp]; ok && dep.Module.Path == modulePath && !g.isLocal(dep) {
+					importers = append(importers, importPath)
+					break imports
+				}
+			}
+		}
+	}
+	sort.Strings(importers)
+	return importers
+}
+
+func diffGraphs(base, head *importGraph) *GraphDiff {
+	diff := &GraphDiff{}
+	basePkgs, headPkgs := base.local(), head.local()
+	for importPath := range headPkgs {
+		if _, ok := basePkgs[importPath]; !ok {
+			diff.AddedPackages = append(diff.AddedPackages, importPath)
+		}
+	}
+	for importPath := range basePkgs {
+		if _, ok := headPkgs[importPath]; !ok {
+			diff.RemovedPackages = append(diff.RemovedPackages, importPath)
+		}
+	}
+	sort.Strings(diff.AddedPackages)
+	sort.Strings(diff.RemovedPackages)
+
+	baseImports, baseTestImports := base.edges()
+	headImports, headTestImports := head.edges()
+	diff.AddedImports = edgesMissing(headImports, baseImports)
+	diff.RemovedImports = edgesMissing(baseImports, headImports)
+	diff.AddedTestImports = edgesMissing(headTestImports, baseTestImports)
+	diff.RemovedTestImports = edgesMissing(baseTestImports, headTestImports)
+
+	baseModules, headModules := base.modules(), head.modules()
+	for path, m := range headModules {
+		if _, ok := baseModules[path]; !ok {
+			m.ImportedBy = head.importers(path)
+			diff.AddedModules = append(diff.AddedModules, m)
+		}
+	}
+	for path, m := range baseModules {
+		if _, ok := headModules[path]; !ok {
+			m.ImportedBy = base.importers(path)
+			diff.RemovedModules = append(diff.RemovedModules, m)
+		}
+	}
+	for _, modules := range [][]ModuleUse{diff.AddedModules, diff.RemovedModules} {
+		sort.Slice(modules, func(i, j int) bool {
+			return modules[i].Path < modules[j].Path
+		})
+	}
+	return diff
+}
+
+// edgesMissing returns the edges of a missing from b, sorted.
+func edgesMissing(a, b map[Edge]bool) []Edge {
+	edges := []Edge(nil)
+	for edge := range a {
+		if !b[edge] {
+			edges = append(edges, edge)
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// write writes the differences to w, one per line.
+func (d *GraphDiff) write(w io.Writer) error {
+	lines := []string(nil)
+	for _, importPath := range d.AddedPackages {
+		lines = append(lines, "added package "+importPath)
+	}
+	for _, importPath := range d.RemovedPackages {
+		lines = append(lines, "removed package "+importPath)
+	}
+	for _, kind := range []struct {
+		name  string
+		edges []Edge
+	}{
+		{"added import", d.AddedImports},
+		{"removed import", d.RemovedImports},
+		{"added test import", d.AddedTestImports},
+		{"removed test import", d.RemovedTestImports},
+	} {
+		for _, edge := range kind.edges {
+			lines = append(lines, fmt.Sprintf("%s %s -> %s", kind.name, edge.From, edge.To))
+		}
+	}
+	for _, kind := range []struct {
+		name    string
+		modules []ModuleUse
+	}{
+		{"added module", d.AddedModules},
+		{"removed module", d.RemovedModules},
+	} {
+		for _, m := range kind.modules {
+			line := strings.TrimSpace(kind.name + " " + m.Path + " " + m.Version)
+			if len(m.ImportedBy) > 0 {
+				line += " (imported by " + strings.Join(m.ImportedBy, ", ") + ")"
+			}
+			lines = append(lines, line)
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
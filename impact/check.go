@@ -0,0 +1,213 @@
+package impact
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Object identifies a package-level object or method by its package path
+// and its key as in Decls. A method called through an interface may be any
+// method of that name, so such calls also refer to an Object with an empty
+// Path and the method name as its Key.
+type Object struct {
+	Path string
+	Key  string
+}
+
+// Package is a type-checked package and the objects its package-level
+// declarations refer to.
+type Package struct {
+	Types *types.Package
+	// Refs maps the key of each declaration to the objects it refers to.
+	Refs map[string]map[Object]bool
+	// Blank are the paths of the packages imported only for their effects.
+	Blank []string
+}
+
+// Check parses and type-checks the files of the package at path.
+func Check(fset *token.FileSet, path string, files []string, conf *types.Config) (*Package, error) {
+	astFiles := []*ast.File(nil)
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		astFiles = append(astFiles, f)
+	}
+	info := &types.Info{
+		Uses: map[*ast.Ident]types.Object{},
+	}
+	typesPkg, err := conf.Check(path, fset, astFiles, info)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	p := &Package{
+		Types: typesPkg,
+		Refs:  map[string]map[Object]bool{},
+	}
+	for _, f := range astFiles {
+		for _, imp := range f.Imports {
+			if imp.Name != nil && imp.Name.Name == "_" {
+				importPath, _ := strconv.Unquote(imp.Path.Value)
+				p.Blank = append(p.Blank, importPath)
+			}
+		}
+		for _, decl := range f.Decls {
+			for node, keys := range declKeys(decl) {
+				refs := map[Object]bool{}
+				ast.Inspect(node, func(n ast.Node) bool {
+					if id, ok := n.(*ast.Ident); ok {
+						for _, obj := range objects(info.Uses[id]) {
+							refs[obj] = true
+						}
+					}
+					return true
+				})
+				for _, key := range keys {
+					if p.Refs[key] == nil {
+						p.Refs[key] = map[Object]bool{}
+					}
+					for obj := range refs {
+						p.Refs[key][obj] = true
+					}
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// Affected returns the keys of the package's declarations that are changed,
+// or that refer to changed objects, directly or through other declarations
+// of the package. Objects of other packages are changed when external
+// reports so. A changed method changes its receiver type too, as a type's
+// method set is part of it.
+func (p *Package) Affected(changed map[string]bool, external func(Object) bool) map[string]bool {
+	affected := map[string]bool{}
+	methods := map[string]bool{}
+	add := func(key string) {
+		affected[key] = true
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			affected[key[:i]] = true
+			methods[key[i+1:]] = true
+		}
+	}
+	for key := range changed {
+		add(key)
+	}
+	refers := func(obj Object) bool {
+		switch obj.Path {
+		case p.Types.Path():
+			return affected[obj.Key]
+		case "":
+			if methods[obj.Key] {
+				return true
+			}
+		}
+		return external(obj)
+	}
+	for grown := true; grown; {
+		grown = false
+		for key, refs := range p.Refs {
+			if affected[key] {
+				continue
+			}
+			for obj := range refs {
+				if refers(obj) {
+					add(key)
+					grown = true
+					break
+				}
+			}
+		}
+	}
+	return affected
+}
+
+// declKeys returns the parts of a declaration that are declarations of
+// their own, mapped to the keys they declare as in Decls.
+func declKeys(decl ast.Decl) map[ast.Node][]string {
+	keys := map[ast.Node][]string{}
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil && decl.Name.Name == "init" {
+			keys[decl] = []string{Init}
+		} else {
+			keys[decl] = []string{FuncKey(decl)}
+		}
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.ValueSpec:
+				node := ast.Node(spec)
+				if decl.Tok == token.CONST {
+					node = decl
+				}
+				for _, name := range spec.Names {
+					if name.Name == "_" {
+						keys[node] = append(keys[node], Init)
+					} else {
+						keys[node] = append(keys[node], name.Name)
+					}
+				}
+			case *ast.TypeSpec:
+				keys[spec] = []string{spec.Name.Name}
+			}
+		}
+	}
+	return keys
+}
+
+// objects returns the Objects that a use of obj refers to, if it is a
+// package-level object or a method.
+func objects(obj types.Object) []Object {
+	if obj == nil || obj.Pkg() == nil {
+		return nil
+	}
+	switch o := obj.(type) {
+	case *types.PkgName:
+		return nil
+	case *types.Var:
+		if o.IsField() {
+			return nil
+		}
+		obj = o.Origin()
+	case *types.Func:
+		o = o.Origin()
+		recv := o.Type().(*types.Signature).Recv()
+		if recv == nil {
+			obj = o
+			break
+		}
+		objs := []Object(nil)
+		if name := namedType(recv.Type()); name != "" {
+			objs = append(objs, Object{Path: o.Pkg().Path(), Key: name + "." + o.Name()})
+		}
+		if types.IsInterface(recv.Type()) {
+			objs = append(objs, Object{Key: o.Name()})
+		}
+		return objs
+	}
+	if obj.Parent() != obj.Pkg().Scope() {
+		return nil
+	}
+	return []Object{{Path: obj.Pkg().Path(), Key: obj.Name()}}
+}
+
+// namedType returns the name of a named type, or a pointer to one.
+func namedType(t types.Type) string {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if named, ok := t.(*types.Named); ok {
+		return named.Obj().Name()
+	}
+	return ""
+}
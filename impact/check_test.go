@@ -0,0 +1,172 @@
+package impact
+
+import (
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+// importer imports the packages type-checked by a test.
+type importer map[string]*types.Package
+
+func (i importer) Import(path string) (*types.Package, error) {
+	if pkg, ok := i[path]; ok {
+		return pkg, nil
+	}
+	return nil, errors.NotFoundf("package %q", path)
+}
+
+func check(t *testing.T, fset *token.FileSet, imports importer, path, src string) *Package {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "p.go")
+	if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := Check(fset, path, []string{file}, &types.Config{Importer: imports})
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports[path] = pkg.Types
+	return pkg
+}
+
+const depSrc = `package dep
+
+type Iface interface{ Do() }
+
+type Impl struct{}
+
+func (Impl) Do() {}
+
+type Box[E any] struct{ v E }
+
+func (b Box[E]) Get() E { return b.v }
+
+func Func() {}
+
+var Var int
+`
+
+const pkgSrc = `package p
+
+import (
+	"example.com/dep"
+	_ "example.com/effects"
+)
+
+type T struct{}
+
+func (T) M() {}
+
+func UsesT() { var t T; _ = t }
+
+func CallsM(t T) { t.M() }
+
+func CallsIface(i dep.Iface) { i.Do() }
+
+func CallsImpl() { dep.Impl{}.Do() }
+
+func CallsGeneric(b dep.Box[int]) int { return b.Get() }
+
+func CallsFunc() { dep.Func() }
+
+func UsesVar() int { return dep.Var }
+
+func Indirect() { CallsFunc() }
+
+func Unrelated() {}
+`
+
+func TestCheck(t *testing.T) {
+	fset := token.NewFileSet()
+	imports := importer{"example.com/effects": types.NewPackage("example.com/effects", "effects")}
+	imports["example.com/effects"].MarkComplete()
+	check(t, fset, imports, "example.com/dep", depSrc)
+	pkg := check(t, fset, imports, "example.com/p", pkgSrc)
+
+	if want := []string{"example.com/effects"}; !reflect.DeepEqual(pkg.Blank, want) {
+		t.Errorf("Blank = %v, want %v", pkg.Blank, want)
+	}
+	tests := []struct {
+		key  string
+		want Object
+	}{
+		{"UsesT", Object{Path: "example.com/p", Key: "T"}},
+		{"CallsM", Object{Path: "example.com/p", Key: "T.M"}},
+		{"CallsIface", Object{Path: "example.com/dep", Key: "Iface.Do"}},
+		{"CallsIface", Object{Key: "Do"}},
+		{"CallsImpl", Object{Path: "example.com/dep", Key: "Impl.Do"}},
+		{"CallsGeneric", Object{Path: "example.com/dep", Key: "Box.Get"}},
+		{"CallsFunc", Object{Path: "example.com/dep", Key: "Func"}},
+		{"UsesVar", Object{Path: "example.com/dep", Key: "Var"}},
+		{"Indirect", Object{Path: "example.com/p", Key: "CallsFunc"}},
+	}
+	for _, test := range tests {
+		if !pkg.Refs[test.key][test.want] {
+			t.Errorf("%s refers to %v, want %v", test.key, pkg.Refs[test.key], test.want)
+		}
+	}
+	if refs := pkg.Refs["CallsImpl"]; refs[Object{Key: "Do"}] {
+		t.Errorf("CallsImpl refers to the interface method Do")
+	}
+}
+
+func TestAffected(t *testing.T) {
+	fset := token.NewFileSet()
+	imports := importer{"example.com/effects": types.NewPackage("example.com/effects", "effects")}
+	imports["example.com/effects"].MarkComplete()
+	check(t, fset, imports, "example.com/dep", depSrc)
+	pkg := check(t, fset, imports, "example.com/p", pkgSrc)
+
+	tests := []struct {
+		name     string
+		changed  map[string]bool
+		external map[Object]bool
+		want     []string
+	}{{
+		name:    "method changes its receiver type",
+		changed: map[string]bool{"T.M": true},
+		want:    []string{"T.M", "T", "CallsM", "UsesT"},
+	}, {
+		name:     "external func through local callers",
+		external: map[Object]bool{{Path: "example.com/dep", Key: "Func"}: true},
+		want:     []string{"CallsFunc", "Indirect"},
+	}, {
+		name:     "generic method by its origin",
+		external: map[Object]bool{{Path: "example.com/dep", Key: "Box.Get"}: true},
+		want:     []string{"CallsGeneric"},
+	}, {
+		name:     "interface method by name",
+		external: map[Object]bool{{Key: "Do"}: true},
+		want:     []string{"CallsIface"},
+	}, {
+		name:    "changed method of the same name affects interface calls",
+		changed: map[string]bool{"T.Do": true},
+		want:    []string{"T.Do", "T", "T.M", "CallsIface", "CallsM", "UsesT"},
+	}, {
+		name:     "external var",
+		external: map[Object]bool{{Path: "example.com/dep", Key: "Var"}: true},
+		want:     []string{"UsesVar"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changed := test.changed
+			if changed == nil {
+				changed = map[string]bool{}
+			}
+			got := pkg.Affected(changed, func(obj Object) bool { return test.external[obj] })
+			want := map[string]bool{}
+			for _, key := range test.want {
+				want[key] = true
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Affected() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package impact
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Init is the key of a package's init funcs and blank vars, which run for
+// their effects rather than being referred to.
+const Init = "init"
+
+// Decls returns the tokens of each package-level declaration in the files
+// of a package, keyed by the name of the func, type, var or const it
+// declares, or Type.Method for a method. Consts declared together share the
+// tokens of their whole group, since iota and implicit repetition tie them.
+func Decls(files [][]byte) (map[string]string, error) {
+	decls := map[string]string{}
+	inits := []string(nil)
+	fset := token.NewFileSet()
+	for _, src := range files {
+		f, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		tokens := func(node ast.Node) string {
+			file := fset.File(node.Pos())
+			return Tokens(src[file.Offset(node.Pos()):file.Offset(node.End())])
+		}
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil && decl.Name.Name == "init" {
+					inits = append(inits, tokens(decl))
+					continue
+				}
+				decls[FuncKey(decl)] = tokens(decl)
+			case *ast.GenDecl:
+				switch decl.Tok {
+				case token.CONST:
+					for _, spec := range decl.Specs {
+						for _, name := range spec.(*ast.ValueSpec).Names {
+							decls[name.Name] = tokens(decl)
+						}
+					}
+				case token.VAR:
+					for _, spec := range decl.Specs {
+						for _, name := range spec.(*ast.ValueSpec).Names {
+							if name.Name == "_" {
+								inits = append(inits, "var "+tokens(spec))
+							} else {
+								decls[name.Name] = "var " + tokens(spec)
+							}
+						}
+					}
+				case token.TYPE:
+					for _, spec := range decl.Specs {
+						decls[spec.(*ast.TypeSpec).Name.Name] = "type " + tokens(spec)
+					}
+				}
+			}
+		}
+	}
+	if len(inits) > 0 {
+		sort.Strings(inits)
+		decls[Init] = strings.Join(inits, "\n")
+	}
+	return decls, nil
+}
+
+// Diff returns the keys of the declarations that differ between past and
+// current, including those that were added or removed.
+func Diff(past, current map[string]string) map[string]bool {
+	changed := map[string]bool{}
+	for key, tokens := range current {
+		if past[key] != tokens {
+			changed[key] = true
+		}
+	}
+	for key := range past {
+		if _, ok := current[key]; !ok {
+			changed[key] = true
+		}
+	}
+	return changed
+}
+
+// FuncKey returns the key of a func or method declaration.
+func FuncKey(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	return receiverName(decl.Recv.List[0].Type) + "." + decl.Name.Name
+}
+
+// receiverName returns the name of the base type of a method receiver.
+func receiverName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package impact
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecls(t *testing.T) {
+	decls, err := Decls([][]byte{[]byte(`package p
+
+const (
+	A = iota
+	B
+)
+
+var x, y = 1, 2
+
+var _ = register()
+
+type T struct{}
+
+func (t *T) M() {}
+
+func (G[E]) N() {}
+
+func F() {}
+
+func init() { a() }
+`), []byte(`package p
+
+func init() { b() }
+`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for key := range decls {
+		keys = append(keys, key)
+	}
+	want := []string{"A", "B", "F", "G.N", "T", "T.M", "init", "x", "y"}
+	for _, key := range want {
+		if _, ok := decls[key]; !ok {
+			t.Errorf("no declaration %q in %v", key, keys)
+		}
+	}
+	if len(decls) != len(want) {
+		t.Errorf("declarations %v, want %v", keys, want)
+	}
+	if decls["A"] != decls["B"] {
+		t.Errorf("consts declared together have different tokens")
+	}
+	if decls["x"] != decls["y"] {
+		t.Errorf("vars declared together have different tokens")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name          string
+		past, current string
+		want          map[string]bool
+	}{{
+		name:    "formatting and comments",
+		past:    "package p\n\n// F does nothing.\nfunc F() {}\n",
+		current: "package p\n\nfunc F() {\n}\n",
+		want:    map[string]bool{},
+	}, {
+		name:    "func body",
+		past:    "package p\n\nfunc F() int { return 1 }\nfunc G() {}\n",
+		current: "package p\n\nfunc F() int { return 2 }\nfunc G() {}\n",
+		want:    map[string]bool{"F": true},
+	}, {
+		name:    "added and removed",
+		past:    "package p\n\nfunc F() {}\n",
+		current: "package p\n\nfunc G() {}\n",
+		want:    map[string]bool{"F": true, "G": true},
+	}, {
+		name:    "const group",
+		past:    "package p\n\nconst (\n\tA = iota\n\tB\n)\n",
+		current: "package p\n\nconst (\n\tA = iota + 1\n\tB\n)\n",
+		want:    map[string]bool{"A": true, "B": true},
+	}, {
+		name:    "method",
+		past:    "package p\n\ntype T int\n\nfunc (T) M() int { return 1 }\n",
+		current: "package p\n\ntype T int\n\nfunc (T) M() int { return 2 }\n",
+		want:    map[string]bool{"T.M": true},
+	}, {
+		name:    "init funcs fold together",
+		past:    "package p\n\nfunc init() { a() }\nfunc init() { b() }\n",
+		current: "package p\n\nfunc init() { b() }\nfunc init() { a() }\n",
+		want:    map[string]bool{},
+	}, {
+		name:    "blank var",
+		past:    "package p\n\nvar _ = a()\n",
+		current: "package p\n\nvar _ = b()\n",
+		want:    map[string]bool{Init: true},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			past, err := Decls([][]byte{[]byte(test.past)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			current, err := Decls([][]byte{[]byte(test.current)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := Diff(past, current); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Diff() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package impact
+
+import (
+	"go/scanner"
+	"go/token"
+	"strings"
+)
+
+// Tokens returns the Go tokens of src, one per line, without comments or
+// formatting. Semicolons may be omitted before a closing ) or }, so whether
+// one was inserted at a line break there is formatting too.
+func Tokens(src []byte) string {
+	tokens := []string(nil)
+	semicolon := false
+	var sc scanner.Scanner
+	sc.Init(token.NewFileSet().AddFile("", -1, len(src)), src, nil, 0)
+	for {
+		_, tok, lit := sc.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if tok == token.SEMICOLON {
+			semicolon = true
+			continue
+		}
+		if semicolon && tok != token.RPAREN && tok != token.RBRACE {
+			tokens = append(tokens, ";")
+		}
+		semicolon = false
+		tokens = append(tokens, tok.String()+" "+lit)
+	}
+	return strings.Join(tokens, "\n")
+}
@@ -0,0 +1,42 @@
+package impact
+
+import "testing"
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{{
+		name: "formatting",
+		a:    "func f(a, b int) int { return a + b }",
+		b:    "func f(a, b int) int {\n\treturn a +\n\t\tb\n}\n",
+		same: true,
+	}, {
+		name: "comments",
+		a:    "// f adds.\nfunc f() int { return 1 /* one */ }",
+		b:    "func f() int { return 1 }",
+		same: true,
+	}, {
+		name: "semicolon before closing brace",
+		a:    "type T struct{ a int; b int }",
+		b:    "type T struct {\n\ta int\n\tb int\n}",
+		same: true,
+	}, {
+		name: "statements",
+		a:    "func f() { a(); b() }",
+		b:    "func f() { a() }",
+	}, {
+		name: "literal",
+		a:    `const s = "a"`,
+		b:    `const s = "b"`,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := Tokens([]byte(test.a)), Tokens([]byte(test.b))
+			if (a == b) != test.same {
+				t.Errorf("Tokens(%q) == Tokens(%q) is %v, want %v", test.a, test.b, a == b, test.same)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// integrationSelection narrows tagged, a selection made with the integration
+// test tags, to the packages whose tag-gated test files are affected. Test
+// files are tag-gated when they are only part of the package with the tags,
+// compared to unit, the selection made without them. Gated test files are
+// affected when they changed, when the package they test is affected, or
+// when a package they import is affected.
+func integrationSelection(unit, tagged *selection) *selection {
+	integration := *tagged
+	integration.needsTest = map[string]bool{}
+	for _, pkg := range tagged.pkgs {
+		importPath := pkg.ImportPath
+		unitFiles := map[string]bool{}
+		for _, file := range unit.pkgsByPath[importPath].TestGoFiles {
+			unitFiles[file] = true
+		}
+		for _, file := range unit.pkgsByPath[importPath].XTestGoFiles {
+			unitFiles[file] = true
+		}
+		gated := []string(nil)
+		for _, files := range [][]string{pkg.TestGoFiles, pkg.XTestGoFiles} {
+			for _, file := range files {
+				if !unitFiles[file] {
+					gated = append(gated, file)
+				}
+			}
+		}
+		if len(gated) == 0 {
+			continue
+		}
+
+		dir := path.Clean(pkg.Dir)
+		if tagged.affected(importPath) || gatedChanged(tagged, dir, gated) {
+			integration.needsTest[importPath] = true
+			continue
+		}
+		for _, file := range gated {
+			for _, imp := range fileImports(filepath.Join(dir, file)) {
+				if imp != importPath && tagged.affected(imp) {
+					integration.needsTest[importPath] = true
+				}
+			}
+		}
+	}
+	return &integration
+}
+
+// gatedChanged reports whether any of the gated test files in dir changed.
+func gatedChanged(s *selection, dir string, gated []string) bool {
+	for _, change := range s.files.test[dir] {
+		for _, file := range gated {
+			if change.Path == filepath.Join(dir, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fileImports returns the import paths of a Go file.
+func fileImports(file string) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	imports := []string(nil)
+	for _, imp := range f.Imports {
+		if importPath, err := strconv.Unquote(imp.Path.Value); err == nil {
+			imports = append(imports, importPath)
+		}
+	}
+	return imports
+}
@@ -4,33 +4,80 @@ import (
 	"flag"
 	"fmt"
 	"go/build"
-	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/dominikbraun/graph"
 	"github.com/juju/errors"
-	"golang.org/x/mod/modfile"
-	"golang.org/x/mod/module"
 
 	"github.com/hpidcock/gochanged/git"
 	"github.com/hpidcock/gochanged/packages"
 )
 
-type P struct {
-	Path    string
-	Test    bool
-	Changed bool
+// stringsFlag is a flag.Value collecting each use of a repeated flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "graphdiff" {
+		if err := graphDiff(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	treeish := ""
 	why := false
-	flag.StringVar(&treeish, "branch", "", "git branch or treeish to diff against")
+	jsonOutput := false
+	groupByModule := false
+	mergeBase := false
+	changeSet := ""
+	whyNotTarget := ""
+	goos := ""
+	goarch := ""
+	tags := ""
+	integrationTags := ""
+	ignoreComments := false
+	precise := false
+	run := false
+	baseGraph := false
+	depSources := false
+	ignorePatterns := stringsFlag(nil)
+	matrix := stringsFlag(nil)
+	flag.StringVar(&treeish, "branch", "", "git branch, treeish or commit range (A..B or A...B) to diff against")
+	flag.BoolVar(&mergeBase, "merge-base", false, "diff against the merge-base of the branch and HEAD")
 	flag.BoolVar(&why, "why", false, "explain why each package changed")
+	flag.StringVar(&whyNotTarget, "why-not", "", "explain why a package was not selected")
+	flag.Var(&ignorePatterns, "ignore", "glob of changed files to ignore, matched against the base name or, with a slash, the path from the git root (repeatable)")
+	flag.BoolVar(&ignoreComments, "ignore-comments", false, "ignore Go files whose changes are only to comments or formatting")
+	flag.BoolVar(&precise, "precise", false, "only select importers that refer to changed funcs, types, methods, vars or consts, found by type-checking")
+	flag.BoolVar(&run, "run", false, "follow each package with a -run pattern matching its tests that refer to changed objects; implies --precise")
+	flag.BoolVar(&baseGraph, "base-graph", false, "also load the packages at the base, in a temporary git worktree, to select importers of removed packages and packages whose imports changed")
+	flag.BoolVar(&depSources, "dep-sources", false, "only treat packages of upgraded modules as changed if their files differ from the previous version in the module cache")
+	flag.BoolVar(&jsonOutput, "json", false, "print a JSON object describing each selected package")
+	flag.BoolVar(&groupByModule, "group-by-module", false, "print one line per module: its directory followed by its selected packages")
+	flag.StringVar(&changeSet, "changes", "committed,staged,unstaged", "comma separated sources of changes to consider (committed, staged, unstaged, untracked)")
+	flag.StringVar(&goos, "goos", "", "GOOS to load packages for")
+	flag.StringVar(&goarch, "goarch", "", "GOARCH to load packages for")
+	flag.StringVar(&tags, "tags", "", "comma separated build tags to load packages with")
+	flag.StringVar(&integrationTags, "integration-tags", "", "comma separated build tags gating integration tests, printed as a separate integration suite")
+	flag.Var(&matrix, "matrix", "GOOS/GOARCH[:tags] to select packages for, printed per entry (repeatable)")
 	flag.Parse()
+	if run && !jsonOutput && (groupByModule || len(matrix) > 0 || integrationTags != "") {
+		fmt.Fprintln(os.Stderr, "--run prints one package per line; use --json to combine it with grouped output")
+		os.Exit(1)
+	}
 	packagesFilter := flag.Args()
 	if len(packagesFilter) == 0 {
 		packagesFilter = []string{"./..."}
@@ -42,237 +89,190 @@ func main() {
 		os.Exit(1)
 	}
 
-	gitRoot, err := git.Root(wd)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+	out := &printer{
+		stdout:        os.Stdout,
+		stderr:        os.Stderr,
+		wd:            wd,
+		why:           why,
+		json:          jsonOutput,
+		groupByModule: groupByModule,
 	}
 
-	pkgs, extraPkgs, err := packages.ImportAll(build.Default, wd, packagesFilter)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
+	buildCtx := build.Default
+	if goos != "" {
+		buildCtx.GOOS = goos
 	}
-
-	commonModFile := ""
-	for _, pkg := range pkgs {
-		if commonModFile == "" {
-			commonModFile = pkg.Module.GoMod
-		} else if commonModFile != pkg.Module.GoMod {
-			fmt.Fprintf(os.Stderr, "no common module found for %v", packagesFilter)
-			os.Exit(1)
-		}
+	if goarch != "" {
+		buildCtx.GOARCH = goarch
 	}
-
-	if !strings.HasPrefix(commonModFile, gitRoot) {
-		fmt.Fprintf(os.Stderr, "%s is not under git root %s", commonModFile, gitRoot)
-		os.Exit(1)
+	if tags != "" {
+		buildCtx.BuildTags = strings.Split(tags, ",")
+	}
+	platforms := []platform{{ctx: buildCtx}}
+	if len(matrix) > 0 {
+		platforms = nil
+		for _, spec := range matrix {
+			p, err := parsePlatform(buildCtx, spec)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			platforms = append(platforms, p)
+		}
 	}
-	goModDir := path.Dir(commonModFile)
-	goModGitSubpath := strings.TrimLeft(strings.TrimPrefix(commonModFile, gitRoot), string(os.PathSeparator))
 
-	currentModFile, err := ioutil.ReadFile(commonModFile)
+	gitRoot, err := git.Root(wd)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	currentMod, err := modfile.Parse(goModGitSubpath, currentModFile, nil)
+
+	rng, err := git.ParseRange(gitRoot, treeish, mergeBase)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 
-	pastModFile, err := git.Read(gitRoot, treeish, goModGitSubpath)
-	if errors.Is(err, errors.NotFound) {
-		for _, pkg := range packagesFilter {
-			fmt.Println(pkg)
-		}
-		os.Exit(0)
-	} else if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(1)
-	}
-	pastMod, err := modfile.Parse(goModGitSubpath, pastModFile, nil)
+	changes, err := git.ParseChangeSet(changeSet)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
 
-	changedDirectories := make(map[string]bool)
-	changedDirectoriesTest := make(map[string]bool)
-	changedPackages := make(map[string]bool)
-	changedTestPackages := make(map[string]bool)
-	whyChanged := make(map[string][]string)
-	whyChangedTests := make(map[string][]string)
-
-	if workspace, err := packages.Workspace(build.Default, wd); err != nil {
+	rng, err = rng.ForChanges(gitRoot, changes)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
-	} else if workspace != "" {
-		for _, pkg := range packagesFilter {
-			if why {
-				fmt.Fprintf(os.Stderr, "%s => workspace mode", pkg)
-			} else {
-				fmt.Println(pkg)
-			}
-		}
-		os.Exit(0)
-	}
-
-	if currentMod.Go.Version != pastMod.Go.Version {
-		for _, pkg := range packagesFilter {
-			if why {
-				fmt.Fprintf(os.Stderr, "%s => go mod version changed", pkg)
-			} else {
-				fmt.Println(pkg)
-			}
-		}
-		os.Exit(0)
-	}
-
-	pastRequires := map[string]module.Version{}
-	for _, dep := range pastMod.Require {
-		pastRequires[dep.Mod.Path] = dep.Mod
-	}
-	pastReplace := map[string]*modfile.Replace{}
-	for _, rep := range pastMod.Replace {
-		pastReplace[rep.Old.Path] = rep
 	}
 
-	// Mark different dependencies as changed.
-	for _, dep := range currentMod.Require {
-		importPath := dep.Mod.Path
-		pastVer, ok := pastRequires[importPath]
-		if !ok {
-			changedPackages[importPath] = true
-			whyChanged[importPath] = append(whyChanged[importPath], fmt.Sprintf("new dep %s", importPath))
-			continue
+	// The head of a commit range is loaded from a worktree, standing in
+	// for the working tree.
+	headRoot, headWd := gitRoot, wd
+	if !rng.WorkingTree() {
+		headRoot, err = git.AddWorktree(gitRoot, rng.Head)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
-		if dep.Mod.Version != pastVer.Version {
-			changedPackages[importPath] = true
-			whyChanged[importPath] = append(whyChanged[importPath], fmt.Sprintf("changed dep %s", importPath))
-			continue
+		rel, err := filepath.Rel(gitRoot, wd)
+		if err != nil {
+			git.RemoveWorktree(gitRoot, headRoot)
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
 		}
+		headWd = filepath.Join(headRoot, rel)
+		out.root, out.headRoot = gitRoot, headRoot
 	}
-	// Mark different replaces as changed.
-	for _, rep := range currentMod.Replace {
-		importPath := rep.Old.Path
-		pastRep, ok := pastReplace[importPath]
-		if !ok {
-			changedPackages[importPath] = true
-			whyChanged[importPath] = append(whyChanged[importPath], fmt.Sprintf("new replace %s", importPath))
-			continue
-		}
-		delete(pastReplace, importPath)
-		if rep.Old.Version != pastRep.Old.Version ||
-			rep.New.Path != pastRep.New.Path ||
-			rep.New.Version != pastRep.New.Version {
-			changedPackages[importPath] = true
-			whyChanged[importPath] = append(whyChanged[importPath], fmt.Sprintf("changed replace %s", importPath))
-			continue
-		}
+
+	opts := &options{
+		wd:        headWd,
+		gitRoot:   headRoot,
+		treeish:   treeish,
+		mergeBase: mergeBase,
+		rng:       rng,
+		changes:   changes,
+		ignore:    ignorePatterns,
+		patterns:  packagesFilter,
+
+		ignoreComments: ignoreComments,
+		precise:        precise || run,
+		run:            run,
+		baseGraph:      baseGraph,
+		depSources:     depSources,
 	}
-	// Mark removed replaces as changed.
-	for importPath := range pastReplace {
-		changedPackages[importPath] = true
-		whyChanged[importPath] = append(whyChanged[importPath], fmt.Sprintf("removed replace %s", importPath))
+	err = selectAll(opts, out, platforms, whyNotTarget, integrationTags)
+	if headRoot != gitRoot {
+		git.RemoveWorktree(gitRoot, headRoot)
 	}
-
-	changedFiles, err := git.DiffNames(gitRoot, treeish)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	for _, file := range changedFiles {
-		dir := path.Dir(file)
-		if strings.Contains(strings.TrimPrefix(dir, goModDir), "testdata") {
-			changedDirectoriesTest[dir] = true
-		} else if strings.HasSuffix(file, "_test.go") {
-			changedDirectoriesTest[dir] = true
-		} else {
-			changedDirectories[dir] = true
-		}
-	}
+	os.Exit(0)
+}
 
-	allPkgs := append(append([]packages.Package(nil), pkgs...), extraPkgs...)
-	for _, v := range pkgs {
-		dir := path.Clean(v.Dir)
-		if changedDirectories[dir] {
-			changedPackages[v.ImportPath] = true
-			whyChanged[v.ImportPath] = append(whyChanged[v.ImportPath], fmt.Sprintf("package changed %s", v.ImportPath))
-		}
-		if changedDirectoriesTest[dir] {
-			changedTestPackages[v.ImportPath] = true
-			whyChangedTests[v.ImportPath] = append(whyChangedTests[v.ImportPath], fmt.Sprintf("tests changed %s", v.ImportPath))
-		}
+// selectAll writes the packages selected for each platform, or explains
+// with --why-not whether whyNotTarget was selected.
+func selectAll(opts *options, out *printer, platforms []platform, whyNotTarget, integrationTags string) error {
+	if _, err := os.Stat(opts.wd); err != nil {
+		return errors.Annotatef(err, "working directory at %s", opts.rng.Head)
+	}
+	changedFiles, err := git.DiffNames(opts.gitRoot, opts.rng, opts.changes)
+	if err != nil {
+		return errors.Trace(err)
 	}
+	opts.changedFiles = changedFiles
 
-	g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
-	for _, pkg := range allPkgs {
-		err := g.AddVertex(pkg.ImportPath)
+	for _, p := range platforms {
+		sel, err := selectPackages(opts, p.ctx)
 		if err != nil {
-			panic(err)
+			return errors.Trace(err)
 		}
-	}
-
-	for _, pkg := range allPkgs {
-		for _, importPath := range pkg.Imports {
-			g.AddEdge(pkg.ImportPath, importPath)
+		if whyNotTarget != "" {
+			if p.name != "" {
+				fmt.Fprintf(os.Stdout, "# %s\n", p.name)
+			}
+			wn := &whyNot{
+				selection: sel,
+				w:         os.Stdout,
+				wd:        opts.wd,
+				gitRoot:   opts.gitRoot,
+			}
+			wn.report(whyNotTarget)
+			continue
 		}
-	}
-
-	needsTest := make(map[string]bool)
-	for _, pkg := range allPkgs {
-		if !changedPackages[pkg.ImportPath] {
+		if integrationTags == "" {
+			out.section(p.name, "")
+			if err := sel.write(out); err != nil {
+				return errors.Trace(err)
+			}
+			sel.writeIgnored(out, opts.gitRoot)
 			continue
 		}
-		needsTest[pkg.ImportPath] = true
-		ReverseDFS(g, pkg.ImportPath, func(importPath string) bool {
-			needsTest[importPath] = true
-			return false
-		})
-	}
 
-	extraNeedsTest := make(map[string]bool)
-nextPackage:
-	for _, pkg := range allPkgs {
-		for _, importPath := range pkg.TestImports {
-			if needsTest[importPath] {
-				extraNeedsTest[pkg.ImportPath] = true
-			}
+		taggedCtx := p.ctx
+		taggedCtx.BuildTags = append(append([]string(nil), p.ctx.BuildTags...), strings.Split(integrationTags, ",")...)
+		tagged, err := selectPackages(opts, taggedCtx)
+		if err != nil {
+			return errors.Trace(err)
 		}
-		for _, importPath := range pkg.XTestImports {
-			if needsTest[importPath] {
-				extraNeedsTest[pkg.ImportPath] = true
-				continue nextPackage
-			}
+		out.section(p.name, "unit")
+		if err := sel.write(out); err != nil {
+			return errors.Trace(err)
+		}
+		sel.writeIgnored(out, opts.gitRoot)
+		out.section(p.name, "integration")
+		if err := integrationSelection(sel, tagged).write(out); err != nil {
+			return errors.Trace(err)
 		}
 	}
+	return errors.Trace(out.flush())
+}
 
-	for importPath := range extraNeedsTest {
-		needsTest[importPath] = true
-		whyChangedTests[importPath] = append(whyChangedTests[importPath], "test deps changed")
+// moduleDir returns the directory containing the go.mod file of the
+// package's module.
+func moduleDir(pkg packages.Package) string {
+	if pkg.Module.GoMod == "" {
+		return pkg.Module.Dir
 	}
+	return filepath.Dir(pkg.Module.GoMod)
+}
 
-	for _, pkg := range pkgs {
-		importPath := pkg.ImportPath
-		if !needsTest[importPath] {
+// changeSources returns the distinct sources of the changes, sorted.
+func changeSources(changes []git.Change) []git.Source {
+	seen := map[git.Source]bool{}
+	sources := []git.Source(nil)
+	for _, change := range changes {
+		if seen[change.Source] {
 			continue
 		}
-		if !why {
-			fmt.Println(importPath)
-			continue
-		}
-		reasonList := append([]string(nil), whyChanged[importPath]...)
-		reasonList = append(reasonList, whyChangedTests[importPath]...)
-		graph.BFS(g, importPath, func(ip string) bool {
-			reasonList = append(reasonList, whyChanged[ip]...)
-			return false
-		})
-		sort.Strings(reasonList)
-		fmt.Fprintf(os.Stderr, "%s => %s\n", importPath, strings.Join(reasonList, "\n	"))
+		seen[change.Source] = true
+		sources = append(sources, change.Source)
 	}
-	os.Exit(0)
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i] < sources[j]
+	})
+	return sources
 }
 
 // Copied from github.com/dominikbraun/graph (with modifications) which is licensed under Apache License.
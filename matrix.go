@@ -0,0 +1,33 @@
+package main
+
+import (
+	"go/build"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// platform is a build context to select packages for.
+type platform struct {
+	name string
+	ctx  build.Context
+}
+
+// parsePlatform parses a --matrix entry of the form GOOS/GOARCH, optionally
+// followed by a colon and comma separated build tags that are added to
+// those of base.
+func parsePlatform(base build.Context, spec string) (platform, error) {
+	target, tags, _ := strings.Cut(spec, ":")
+	goos, goarch, ok := strings.Cut(target, "/")
+	if !ok || goos == "" || goarch == "" {
+		return platform{}, errors.NotValidf("matrix entry %q, want GOOS/GOARCH[:tags]", spec)
+	}
+	ctx := base
+	ctx.GOOS = goos
+	ctx.GOARCH = goarch
+	ctx.BuildTags = append([]string(nil), base.BuildTags...)
+	if tags != "" {
+		ctx.BuildTags = append(ctx.BuildTags, strings.Split(tags, ",")...)
+	}
+	return platform{name: spec, ctx: ctx}, nil
+}
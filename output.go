@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hpidcock/gochanged/gomod"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// printer writes the selection in the format chosen on the command line.
+// Import paths, each followed by its -run pattern if known, are written to
+// stdout, --why explanations to stderr and
+// --json objects to stdout. With groupByModule, a --matrix platform or a
+// test suite, import paths are instead written once flush is called, on one
+// line per group prefixed by the platform, the suite and the module
+// directory relative to wd.
+type printer struct {
+	stdout        io.Writer
+	stderr        io.Writer
+	wd            string
+	why           bool
+	json          bool
+	groupByModule bool
+	// headRoot, when set, is the worktree the packages were loaded from,
+	// whose directories are written as those under root.
+	root, headRoot string
+
+	platform string
+	suite    string
+	order    []string
+	groups   map[string][]string
+}
+
+// section sets the --matrix entry and the test suite the following packages
+// are selected for.
+func (p *printer) section(platform, suite string) {
+	p.platform = platform
+	p.suite = suite
+	if p.current() != "" && !p.groupByModule && !p.why && !p.json {
+		p.group(p.current())
+	}
+}
+
+func (p *printer) current() string {
+	return strings.TrimSpace(p.platform + " " + p.suite)
+}
+
+func (p *printer) group(key string) {
+	if p.groups == nil {
+		p.groups = map[string][]string{}
+	}
+	if _, ok := p.groups[key]; !ok {
+		p.groups[key] = []string{}
+		p.order = append(p.order, key)
+	}
+}
+
+func (p *printer) selected(s Selected) error {
+	s.Platform = p.platform
+	s.Suite = p.suite
+	s.Dir, s.ModuleDir = p.rebase(s.Dir), p.rebase(s.ModuleDir)
+	sort.Slice(s.Reasons, func(i, j int) bool {
+		return s.Reasons[i].String() < s.Reasons[j].String()
+	})
+	reasons := s.Reasons[:0]
+	for i, reason := range s.Reasons {
+		if i > 0 && reason.String() == s.Reasons[i-1].String() {
+			continue
+		}
+		reasons = append(reasons, reason)
+	}
+	s.Reasons = reasons
+	if p.json {
+		b, err := json.MarshalIndent(s, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(p.stdout, "%s\n", b)
+		return err
+	}
+	if !p.why && (p.groupByModule || p.current() != "") {
+		key := p.current()
+		if p.groupByModule {
+			rel, err := filepath.Rel(p.wd, s.ModuleDir)
+			if err != nil {
+				rel = s.ModuleDir
+			}
+			key = strings.TrimSpace(key + " " + rel)
+		}
+		p.group(key)
+		p.groups[key] = append(p.groups[key], s.ImportPath)
+		return nil
+	}
+	if !p.why {
+		_, err := fmt.Fprintln(p.stdout, strings.TrimSpace(s.ImportPath+" "+s.Run))
+		return err
+	}
+	lines := []string(nil)
+	for _, reason := range s.Reasons {
+		lines = append(lines, reason.String())
+	}
+	name := s.ImportPath
+	if s.Run != "" {
+		name += " -run " + s.Run
+	}
+	if p.current() != "" {
+		name += " [" + p.current() + "]"
+	}
+	_, err := fmt.Fprintf(p.stderr, "%s => %s\n", name, strings.Join(lines, "\n	"))
+	return err
+}
+
+// rebase returns dir, if in the worktree at headRoot, as the same directory
+// under root.
+func (p *printer) rebase(dir string) string {
+	if p.headRoot == "" || !packages.Within(dir, p.headRoot) {
+		return dir
+	}
+	return filepath.Join(p.root, strings.TrimPrefix(dir, p.headRoot))
+}
+
+// ignored explains, with --why or --json, a changed file, or a change to a
+// go.mod directive, that selected nothing.
+func (p *printer) ignored(file, reason string, event *gomod.Event) {
+	if p.json {
+		b, err := json.MarshalIndent(Ignored{Ignored: file, Reason: reason, Event: event, Platform: p.platform, Suite: p.suite}, "", "\t")
+		if err == nil {
+			fmt.Fprintf(p.stdout, "%s\n", b)
+		}
+		return
+	}
+	if p.why {
+		if p.current() != "" {
+			file += " [" + p.current() + "]"
+		}
+		fmt.Fprintf(p.stderr, "%s => ignored (%s)\n", file, reason)
+	}
+}
+
+// flush writes any grouped output.
+func (p *printer) flush() error {
+	for _, key := range p.order {
+		line := strings.TrimSpace(key + " " + strings.Join(p.groups[key], " "))
+		if _, err := fmt.Fprintln(p.stdout, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
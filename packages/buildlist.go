@@ -0,0 +1,72 @@
+package packages
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// BuildList returns the modules selected for the build of the module at dir,
+// as `go list -m all` lists them, keyed by module path. The go.mod and
+// go.sum files are never updated, and any go.work file is ignored. When
+// offline, modules are only read from the module cache.
+func BuildList(dir string, offline bool) (map[string]Module, error) {
+	stdout, err := goMod(dir, offline, "list", "-m", "-json", "all")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	modules := map[string]Module{}
+	decoder := json.NewDecoder(stdout)
+	for decoder.More() {
+		m := Module{}
+		if err := decoder.Decode(&m); err != nil {
+			return nil, errors.Trace(err)
+		}
+		if m.Error != nil {
+			return nil, errors.Errorf("module %s: %s", m.Path, m.Error.Err)
+		}
+		modules[m.Path] = m
+	}
+	return modules, nil
+}
+
+// Requirers returns, for each required module version, the modules that
+// require it, as `go mod graph` lists them for the module at dir. Module
+// versions are written path@version, and the main module as its path.
+func Requirers(dir string, offline bool) (map[string][]string, error) {
+	stdout, err := goMod(dir, offline, "mod", "graph")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	requirers := map[string][]string{}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		from, to, ok := strings.Cut(scanner.Text(), " ")
+		if ok {
+			requirers[to] = append(requirers[to], from)
+		}
+	}
+	return requirers, errors.Trace(scanner.Err())
+}
+
+func goMod(dir string, offline bool, args ...string) (*bytes.Buffer, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=readonly", "GOWORK=off")
+	if offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Annotate(err, stderr.String())
+	}
+	return stdout, nil
+}
@@ -0,0 +1,150 @@
+package packages
+
+import (
+	"bufio"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var includeRegexp = regexp.MustCompile(`^\s*#\s*include\s*([<"])([^>"]+)[>"]`)
+
+// Includes returns the files included, directly or transitively, by the
+// package's cgo preambles and its C, C++, Objective-C, header and assembly
+// files. Quoted includes are resolved relative to the including file, then
+// like angle-bracket includes against the -I flags of the cgo directives.
+// Includes that cannot be found, such as system headers, are skipped.
+func Includes(pkg Package) []string {
+	includeDirs := includeDirs(pkg)
+
+	queue := []include(nil)
+	for _, file := range pkg.CgoFiles {
+		queue = append(queue, cgoIncludes(filepath.Join(pkg.Dir, file))...)
+	}
+	for _, files := range [][]string{pkg.CFiles, pkg.CXXFiles, pkg.MFiles, pkg.HFiles, pkg.SFiles} {
+		for _, file := range files {
+			queue = append(queue, fileIncludes(filepath.Join(pkg.Dir, file))...)
+		}
+	}
+
+	seen := map[string]bool{}
+	includes := []string(nil)
+	for len(queue) > 0 {
+		inc := queue[0]
+		queue = queue[1:]
+		file, ok := resolveInclude(inc, includeDirs)
+		if !ok || seen[file] {
+			continue
+		}
+		seen[file] = true
+		includes = append(includes, file)
+		queue = append(queue, fileIncludes(file)...)
+	}
+	return includes
+}
+
+func includeDirs(pkg Package) []string {
+	dirs := []string(nil)
+	for _, flags := range [][]string{pkg.CgoCPPFLAGS, pkg.CgoCFLAGS, pkg.CgoCXXFLAGS} {
+		for i := 0; i < len(flags); i++ {
+			dir := ""
+			if flags[i] == "-I" && i+1 < len(flags) {
+				i++
+				dir = flags[i]
+			} else if strings.HasPrefix(flags[i], "-I") {
+				dir = flags[i][2:]
+			} else {
+				continue
+			}
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(pkg.Dir, dir)
+			}
+			dirs = append(dirs, filepath.Clean(dir))
+		}
+	}
+	return dirs
+}
+
+func resolveInclude(inc include, includeDirs []string) (string, bool) {
+	candidates := []string(nil)
+	if inc.quoted {
+		candidates = append(candidates, filepath.Join(inc.dir, inc.name))
+	}
+	for _, dir := range includeDirs {
+		candidates = append(candidates, filepath.Join(dir, inc.name))
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// fileIncludes returns the include directives of a C-like source file.
+func fileIncludes(file string) []include {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	includes := []include(nil)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		includes = appendInclude(includes, filepath.Dir(file), scanner.Text())
+	}
+	return includes
+}
+
+// cgoIncludes returns the include directives of the preambles of import "C"
+// in a Go file.
+func cgoIncludes(file string) []include {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	includes := []include(nil)
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			imp := spec.(*ast.ImportSpec)
+			if path, err := strconv.Unquote(imp.Path.Value); err != nil || path != "C" {
+				continue
+			}
+			preamble := imp.Doc
+			if preamble == nil && !gen.Lparen.IsValid() {
+				preamble = gen.Doc
+			}
+			if preamble == nil {
+				continue
+			}
+			for _, line := range strings.Split(preamble.Text(), "\n") {
+				includes = appendInclude(includes, filepath.Dir(file), line)
+			}
+		}
+	}
+	return includes
+}
+
+// include is an include directive found in a file in dir.
+type include struct {
+	dir    string
+	quoted bool
+	name   string
+}
+
+func appendInclude(includes []include, dir, line string) []include {
+	match := includeRegexp.FindStringSubmatch(line)
+	if match == nil {
+		return includes
+	}
+	return append(includes, include{dir: dir, quoted: match[1] == `"`, name: match[2]})
+}
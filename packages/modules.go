@@ -0,0 +1,135 @@
+package packages
+
+import (
+	"go/build"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// Modules returns the root directories of the module containing dir, if
+// any, and of every module nested under dir. Directories the go command
+// ignores, such as vendor and testdata, are skipped.
+func Modules(buildCtx build.Context, dir string) ([]string, error) {
+	moduleDirs := []string(nil)
+	goMod, err := GoEnv(buildCtx, dir, "GOMOD")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if goMod != "" && goMod != os.DevNull {
+		moduleDirs = append(moduleDirs, filepath.Dir(goMod))
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() == "go.mod" && filepath.Dir(path) != filepath.Dir(goMod) {
+			moduleDirs = append(moduleDirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return moduleDirs, nil
+}
+
+// GroupPatterns assigns each pattern to the innermost module directory that
+// contains it, rewriting relative patterns to be relative to that module.
+// Patterns that are not relative belong to the module containing dir. The
+// patterns should first be expanded with ExpandPatterns.
+func GroupPatterns(dir string, moduleDirs []string, patterns []string) map[string][]string {
+	groups := map[string][]string{}
+	for _, pattern := range patterns {
+		if !isRelative(pattern) {
+			moduleDir := innermost(dir, moduleDirs)
+			groups[moduleDir] = append(groups[moduleDir], pattern)
+			continue
+		}
+		base, recursive := patternDir(dir, pattern)
+		moduleDir := innermost(base, moduleDirs)
+		rel, err := filepath.Rel(moduleDir, base)
+		if err != nil {
+			groups[dir] = append(groups[dir], pattern)
+			continue
+		}
+		switch {
+		case rel == "." && recursive:
+			rel = "./..."
+		case rel == ".":
+		case recursive:
+			rel = "./" + filepath.ToSlash(rel) + "/..."
+		default:
+			rel = "./" + filepath.ToSlash(rel)
+		}
+		groups[moduleDir] = append(groups[moduleDir], rel)
+	}
+	return groups
+}
+
+// innermost returns the deepest module directory containing path, or path
+// itself when no module contains it.
+func innermost(path string, moduleDirs []string) string {
+	found := ""
+	for _, moduleDir := range moduleDirs {
+		if Within(path, moduleDir) && len(moduleDir) > len(found) {
+			found = moduleDir
+		}
+	}
+	if found == "" {
+		return path
+	}
+	return found
+}
+
+// ImportModules runs ImportAll from each module directory with its group of
+// patterns, merging the results. Packages matched by a pattern in any
+// module take precedence over those loaded only as a dependency.
+func ImportModules(buildCtx build.Context, groups map[string][]string) ([]Package, []Package, error) {
+	moduleDirs := []string(nil)
+	for moduleDir := range groups {
+		moduleDirs = append(moduleDirs, moduleDir)
+	}
+	sort.Strings(moduleDirs)
+
+	pkgs := []Package(nil)
+	extraPkgs := []Package(nil)
+	for _, moduleDir := range moduleDirs {
+		modulePkgs, moduleExtraPkgs, err := ImportAll(buildCtx, moduleDir, groups[moduleDir])
+		if err != nil {
+			return nil, nil, errors.Annotatef(err, "loading packages in %s", moduleDir)
+		}
+		pkgs = append(pkgs, modulePkgs...)
+		extraPkgs = append(extraPkgs, moduleExtraPkgs...)
+	}
+
+	paths := map[string]struct{}{}
+	pkgs = dedupe(pkgs, paths)
+	extraPkgs = dedupe(extraPkgs, paths)
+	return pkgs, extraPkgs, nil
+}
+
+// dedupe drops packages whose import path is already in paths, adding the
+// rest.
+func dedupe(pkgs []Package, paths map[string]struct{}) []Package {
+	deduped := []Package(nil)
+	for _, pkg := range pkgs {
+		if _, ok := paths[pkg.ImportPath]; !ok {
+			paths[pkg.ImportPath] = struct{}{}
+			deduped = append(deduped, pkg)
+		}
+	}
+	return deduped
+}
@@ -0,0 +1,77 @@
+package packages
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPatterns rewrites recursive relative patterns, such as ./..., that
+// span the given module directories into one pattern per module. The go
+// command does not match packages across module boundaries for a directory
+// that is not itself inside a module, such as the root of a workspace.
+func ExpandPatterns(dir string, moduleDirs []string, patterns []string) []string {
+	expanded := []string(nil)
+	for _, pattern := range patterns {
+		if !isRelative(pattern) {
+			expanded = append(expanded, pattern)
+			continue
+		}
+		base, recursive := patternDir(dir, pattern)
+		if !recursive {
+			expanded = append(expanded, pattern)
+			continue
+		}
+
+		inModule := false
+		for _, moduleDir := range moduleDirs {
+			if Within(base, moduleDir) {
+				inModule = true
+				break
+			}
+		}
+		if inModule {
+			expanded = append(expanded, pattern)
+		}
+
+		added := false
+		for _, moduleDir := range moduleDirs {
+			if moduleDir == base || !Within(moduleDir, base) {
+				continue
+			}
+			rel, err := filepath.Rel(dir, moduleDir)
+			if err != nil {
+				continue
+			}
+			if !strings.HasPrefix(rel, "..") {
+				rel = "." + string(filepath.Separator) + rel
+			}
+			expanded = append(expanded, filepath.ToSlash(rel)+"/...")
+			added = true
+		}
+		if !inModule && !added {
+			expanded = append(expanded, pattern)
+		}
+	}
+	return expanded
+}
+
+func isRelative(pattern string) bool {
+	return pattern == "." || pattern == ".." ||
+		strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../") ||
+		filepath.IsAbs(pattern)
+}
+
+// patternDir returns the directory a relative pattern refers to and whether
+// it matches recursively.
+func patternDir(dir, pattern string) (string, bool) {
+	base, recursive := strings.CutSuffix(pattern, "...")
+	if filepath.IsAbs(base) {
+		return filepath.Clean(base), recursive
+	}
+	return filepath.Join(dir, base), recursive
+}
+
+// Within reports whether path is dir or is inside dir.
+func Within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
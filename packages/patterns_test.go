@@ -0,0 +1,103 @@
+package packages
+
+import (
+	"reflect"
+	"testing"
+)
+
+var moduleDirs = []string{"/w/a", "/w/a/nested", "/w/b"}
+
+func TestExpandPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		dir      string
+		patterns []string
+		want     []string
+	}{{
+		name:     "all from outside any module",
+		dir:      "/w",
+		patterns: []string{"./..."},
+		want:     []string{"./a/...", "./a/nested/...", "./b/..."},
+	}, {
+		name:     "all from a module with a nested one",
+		dir:      "/w/a",
+		patterns: []string{"./..."},
+		want:     []string{"./...", "./nested/..."},
+	}, {
+		name:     "subdirectory containing a nested module",
+		dir:      "/w",
+		patterns: []string{"./a/..."},
+		want:     []string{"./a/...", "./a/nested/..."},
+	}, {
+		name:     "sibling module",
+		dir:      "/w/a",
+		patterns: []string{"../b/..."},
+		want:     []string{"../b/..."},
+	}, {
+		name:     "no module below",
+		dir:      "/w",
+		patterns: []string{"./c/..."},
+		want:     []string{"./c/..."},
+	}, {
+		name:     "packages",
+		dir:      "/w/a",
+		patterns: []string{".", "./nested/p", "../b/q", "example.com/x/..."},
+		want:     []string{".", "./nested/p", "../b/q", "example.com/x/..."},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExpandPatterns(test.dir, moduleDirs, test.patterns); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExpandPatterns(%q, %q) = %q, want %q", test.dir, test.patterns, got, test.want)
+			}
+		})
+	}
+}
+
+func TestGroupPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		dir      string
+		patterns []string
+		want     map[string][]string
+	}{{
+		name:     "expanded all",
+		dir:      "/w",
+		patterns: []string{"./a/...", "./a/nested/...", "./b/..."},
+		want: map[string][]string{
+			"/w/a":        {"./..."},
+			"/w/a/nested": {"./..."},
+			"/w/b":        {"./..."},
+		},
+	}, {
+		name:     "nested module",
+		dir:      "/w/a",
+		patterns: []string{"./...", "./nested/...", "./nested/p", "./nested/p/..."},
+		want: map[string][]string{
+			"/w/a":        {"./..."},
+			"/w/a/nested": {"./...", "./p", "./p/..."},
+		},
+	}, {
+		name:     "packages",
+		dir:      "/w/a",
+		patterns: []string{".", "./p", "../b/q", "example.com/x"},
+		want: map[string][]string{
+			"/w/a": {".", "./p", "example.com/x"},
+			"/w/b": {"./q"},
+		},
+	}, {
+		name:     "outside any module",
+		dir:      "/w",
+		patterns: []string{"./c/...", "example.com/x"},
+		want: map[string][]string{
+			"/w/c": {"./..."},
+			"/w":   {"example.com/x"},
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := GroupPatterns(test.dir, moduleDirs, test.patterns); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("GroupPatterns(%q, %q) = %q, want %q", test.dir, test.patterns, got, test.want)
+			}
+		})
+	}
+}
@@ -4,13 +4,24 @@ import (
 	"bytes"
 	"encoding/json"
 	"go/build"
+	"os"
 	"os/exec"
+	"strings"
 
 	"github.com/juju/errors"
 )
 
+// Workspace returns the path of the go.work file in use, or an empty string
+// when workspace mode is disabled.
 func Workspace(buildCtx build.Context, dir string) (string, error) {
-	return GoEnv(buildCtx, dir, "GOWORK")
+	workFile, err := GoEnv(buildCtx, dir, "GOWORK")
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	if workFile == "off" {
+		return "", nil
+	}
+	return workFile, nil
 }
 
 func GoEnv(buildCtx build.Context, dir string, env string) (string, error) {
@@ -81,22 +92,51 @@ func ImportAll(buildCtx build.Context, dir string, packages []string) ([]Package
 }
 
 func internalImportAll(buildCtx build.Context, dir string, packages []string, test bool) ([]Package, error) {
+	if test {
+		return goList(buildCtx, dir, nil, packages)
+	}
+	return goList(buildCtx, dir, []string{"-deps"}, packages)
+}
+
+// Exports returns the export data files of the packages and of their
+// dependencies, including those of their tests, keyed by import path.
+// Packages are compiled as needed, and those that fail to compile are
+// omitted.
+func Exports(buildCtx build.Context, dir string, packages []string) (map[string]string, error) {
+	pkgs, err := goList(buildCtx, dir, []string{"-deps", "-test", "-export"}, packages)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	exports := map[string]string{}
+	for _, pkg := range pkgs {
+		if pkg.Export != "" && pkg.ForTest == "" {
+			exports[pkg.ImportPath] = pkg.Export
+		}
+	}
+	return exports, nil
+}
+
+func goList(buildCtx build.Context, dir string, flags []string, packages []string) ([]Package, error) {
 	if len(packages) == 0 {
 		return nil, nil
 	}
 
 	args := []string{"list", "-e", "-json", "-compiler", buildCtx.Compiler}
-	if !test {
-		args = append(args, "-deps")
+	if len(buildCtx.BuildTags) > 0 {
+		args = append(args, "-tags", strings.Join(buildCtx.BuildTags, ","))
 	}
+	args = append(args, flags...)
 
 	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
 	cmd := exec.Command("go", append(append(args, "--"), packages...)...)
 	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS="+buildCtx.GOOS, "GOARCH="+buildCtx.GOARCH)
 	err := cmd.Run()
 	if err != nil {
-		return nil, errors.Trace(err)
+		return nil, errors.Annotate(err, stderr.String())
 	}
 
 	pkgs := []Package(nil)
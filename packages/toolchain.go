@@ -0,0 +1,56 @@
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/juju/errors"
+)
+
+// Toolchain is the go command's environment outside any module, describing
+// the toolchain it runs unless a go.mod file requires a newer one.
+type Toolchain struct {
+	GOVERSION   string
+	GOROOT      string
+	GOMODCACHE  string
+	GOTOOLCHAIN string
+	GOHOSTOS    string
+	GOHOSTARCH  string
+}
+
+// DefaultToolchain returns the environment of the toolchain the go command
+// runs outside any module.
+func DefaultToolchain() (Toolchain, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("go", "env", "-json", "GOVERSION", "GOROOT", "GOMODCACHE", "GOTOOLCHAIN", "GOHOSTOS", "GOHOSTARCH")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Dir = os.TempDir()
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	if err := cmd.Run(); err != nil {
+		return Toolchain{}, errors.Annotate(err, stderr.String())
+	}
+	t := Toolchain{}
+	if err := json.Unmarshal(stdout.Bytes(), &t); err != nil {
+		return Toolchain{}, errors.Trace(err)
+	}
+	return t, nil
+}
+
+// Root returns the GOROOT of the toolchain version, such as go1.22.0, when
+// it is the default toolchain or has been downloaded to the module cache,
+// or an empty string when it is not available locally.
+func (t Toolchain) Root(version string) string {
+	if version == t.GOVERSION {
+		return t.GOROOT
+	}
+	root := filepath.Join(t.GOMODCACHE, "golang.org", "toolchain@v0.0.1-"+version+"."+t.GOHOSTOS+"-"+t.GOHOSTARCH)
+	if _, err := os.Stat(filepath.Join(root, "src")); err != nil {
+		return ""
+	}
+	return root
+}
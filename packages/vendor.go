@@ -0,0 +1,58 @@
+package packages
+
+import (
+	"strings"
+)
+
+// VendoredModule is a module listed in vendor/modules.txt.
+type VendoredModule struct {
+	Path      string
+	Version   string
+	Replace   string   // replacement path and version, if any
+	GoVersion string   // go version of the module, if listed
+	Packages  []string // import paths of the vendored packages
+}
+
+// ParseVendorModules parses the contents of a vendor/modules.txt file,
+// returning its modules keyed by module path.
+func ParseVendorModules(data []byte) map[string]VendoredModule {
+	modules := map[string]VendoredModule{}
+	current := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "## "):
+			m, ok := modules[current]
+			if !ok {
+				continue
+			}
+			for _, annotation := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(annotation), "go "); ok {
+					m.GoVersion = v
+				}
+			}
+			modules[current] = m
+		case strings.HasPrefix(line, "# "):
+			fields := strings.Fields(strings.TrimPrefix(line, "# "))
+			m := VendoredModule{}
+			if len(fields) > 0 {
+				m.Path = fields[0]
+			}
+			for i, field := range fields[1:] {
+				if field == "=>" {
+					m.Replace = strings.Join(fields[i+2:], " ")
+					break
+				}
+				m.Version = field
+			}
+			current = m.Path
+			modules[current] = m
+		case line != "":
+			if m, ok := modules[current]; ok {
+				m.Packages = append(m.Packages, line)
+				modules[current] = m
+			}
+		}
+	}
+	return modules
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"go/build"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dominikbraun/graph"
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/impact"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// precise propagates changes along references to changed objects rather
+// than along every import. Packages it cannot analyse, such as those changed
+// by their module, using cgo or failing to type-check, are changed as a
+// whole and affect all of their importers.
+type precise struct {
+	s            *selection
+	opts         *options
+	buildCtx     build.Context
+	fset         *token.FileSet
+	wholeChanged map[string]bool
+	compared     map[string]bool
+	importers    map[string]types.Importer
+
+	// whole are the packages changed as a whole, objects the changed
+	// objects of the others and methods the names of all changed methods.
+	whole   map[string]bool
+	objects map[string]map[string]bool
+	methods map[string]bool
+}
+
+// propagateObjects selects the packages affected by the changed packages,
+// and those whose tests are, following references to changed objects.
+func (s *selection) propagateObjects(opts *options, buildCtx build.Context, g graph.Graph[string, string], comparedPkgs []packages.Package, wholeChanged map[string]bool) error {
+	p := &precise{
+		s:            s,
+		opts:         opts,
+		buildCtx:     buildCtx,
+		fset:         token.NewFileSet(),
+		wholeChanged: wholeChanged,
+		compared:     map[string]bool{},
+		importers:    map[string]types.Importer{},
+		whole:        map[string]bool{},
+		objects:      map[string]map[string]bool{},
+		methods:      map[string]bool{},
+	}
+	for _, pkg := range comparedPkgs {
+		p.compared[pkg.ImportPath] = packages.Within(pkg.Dir, opts.gitRoot)
+	}
+
+	// Only the changed packages and their importers can be affected, and
+	// only the tests importing those.
+	cone := map[string]bool{}
+	for importPath := range s.changedPackages {
+		ReverseDFS(g, importPath, func(importPath string) bool {
+			cone[importPath] = true
+			return false
+		})
+	}
+	candidates := []packages.Package(nil)
+	testCandidates := []packages.Package(nil)
+	for _, pkg := range comparedPkgs {
+		if cone[pkg.ImportPath] {
+			candidates = append(candidates, pkg)
+		} else if importsAny(pkg, cone) {
+			candidates = append(candidates, pkg)
+			testCandidates = append(testCandidates, pkg)
+		} else if s.changedTestPackages[pkg.ImportPath] && opts.run {
+			candidates = append(candidates, pkg)
+		}
+	}
+	if err := p.loadExports(candidates); err != nil {
+		return errors.Trace(err)
+	}
+
+	order, err := graph.TopologicalSort(g)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// Visit imports before their importers.
+	for i := len(order) - 1; i >= 0; i-- {
+		importPath := order[i]
+		if !cone[importPath] {
+			continue
+		}
+		pkg := s.pkgsByPath[importPath]
+		if !s.changedPackages[importPath] && !p.importsAffected(pkg.Imports) {
+			testCandidates = append(testCandidates, pkg)
+			continue
+		}
+		objects, whole := p.analyse(pkg)
+		if !whole && len(objects) == 0 && !s.changedPackages[importPath] {
+			testCandidates = append(testCandidates, pkg)
+			continue
+		}
+		p.whole[importPath] = whole
+		p.objects[importPath] = objects
+		for key := range objects {
+			if i := strings.LastIndex(key, "."); i >= 0 {
+				p.methods[key[i+1:]] = true
+			}
+		}
+		s.needsTest[importPath] = true
+		if !s.changedPackages[importPath] {
+			s.dependencyChanged[importPath] = true
+		}
+	}
+	for importPath := range s.changedTestPackages {
+		s.needsTest[importPath] = true
+	}
+	for importPath, reasons := range s.whyChanged {
+		for i := range reasons {
+			if reasons[i].Code == ReasonPackageChanged && !p.whole[importPath] {
+				reasons[i].Objects = sortedKeys(p.objects[importPath])
+			}
+		}
+	}
+
+	for _, pkg := range testCandidates {
+		if s.needsTest[pkg.ImportPath] || !p.compared[pkg.ImportPath] ||
+			!p.importsAffected(pkg.Imports, pkg.TestImports, pkg.XTestImports) {
+			continue
+		}
+		if p.testsAffected(pkg) {
+			s.needsTest[pkg.ImportPath] = true
+			s.testDepsChanged[pkg.ImportPath] = true
+			s.whyChangedTests[pkg.ImportPath] = append(s.whyChangedTests[pkg.ImportPath], Reason{Code: ReasonTestDepsChanged})
+		}
+	}
+
+	if opts.run {
+		for _, pkg := range s.pkgs {
+			if !s.needsTest[pkg.ImportPath] {
+				continue
+			}
+			if pattern, ok := p.runPattern(pkg); ok {
+				s.runs[pkg.ImportPath] = pattern
+			}
+		}
+	}
+	return nil
+}
+
+// loadExports compiles the packages' dependencies, from the root of each of
+// their modules, for type-checking them.
+func (p *precise) loadExports(pkgs []packages.Package) error {
+	byModule := map[string][]string{}
+	for _, pkg := range pkgs {
+		if p.compared[pkg.ImportPath] {
+			byModule[moduleDir(pkg)] = append(byModule[moduleDir(pkg)], pkg.ImportPath)
+		}
+	}
+	for dir, importPaths := range byModule {
+		exports, err := packages.Exports(p.buildCtx, dir, importPaths)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		p.importers[dir] = importer.ForCompiler(p.fset, "gc", func(importPath string) (io.ReadCloser, error) {
+			file, ok := exports[importPath]
+			if !ok {
+				return nil, errors.NotFoundf("export data for %q", importPath)
+			}
+			return os.Open(file)
+		})
+	}
+	return nil
+}
+
+// analyse returns the changed objects of a package in the cone of the
+// changes, or whether it is changed as a whole.
+func (p *precise) analyse(pkg packages.Package) (map[string]bool, bool) {
+	if p.wholeChanged[pkg.ImportPath] || !p.compared[pkg.ImportPath] || len(pkg.CgoFiles) > 0 {
+		return nil, true
+	}
+	for _, importPath := range pkg.Imports {
+		if p.whole[importPath] {
+			return nil, true
+		}
+	}
+	changed := map[string]bool(nil)
+	if changes := p.s.files.build[path.Clean(pkg.Dir)]; len(changes) > 0 {
+		var ok bool
+		if changed, ok = p.diff(pkg, pkg.GoFiles, changes); !ok {
+			return nil, true
+		}
+	}
+	checked, err := p.check(pkg, pkg.ImportPath, pkg.GoFiles, nil)
+	if err != nil || p.blankAffected(pkg, checked) {
+		return nil, true
+	}
+	objects := checked.Affected(changed, p.changed)
+	if objects[impact.Init] {
+		return nil, true
+	}
+	return objects, false
+}
+
+// diff returns the package-level declarations of the files of the package
+// that differ from the base, if the changes to them are all to Go files that
+// can be compared. Changed files missing from files were removed.
+func (p *precise) diff(pkg packages.Package, files []string, changes []git.Change) (map[string]bool, bool) {
+	if len(changes) == 0 {
+		return map[string]bool{}, true
+	}
+	names := append([]string(nil), files...)
+	for _, change := range changes {
+		name := filepath.Base(change.Path)
+		if filepath.Dir(change.Path) != filepath.Clean(pkg.Dir) || filepath.Ext(name) != ".go" {
+			return nil, false
+		}
+		if !contains(files, name) {
+			names = append(names, name)
+		}
+		// Directives such as //go:embed and //go:linkname are comments.
+		if !sameDirectives(p.opts.gitRoot, p.opts.rng, change.Path) {
+			return nil, false
+		}
+		// The declarations are compared without comments, which include
+		// the expected output of examples.
+		if strings.HasSuffix(name, "_test.go") && !sameExampleOutputs(p.opts.gitRoot, p.opts.rng, change.Path) {
+			return nil, false
+		}
+	}
+
+	past := [][]byte(nil)
+	current := [][]byte(nil)
+	for _, name := range names {
+		file := filepath.Join(pkg.Dir, name)
+		src, err := readBase(p.opts.gitRoot, p.opts.rng, file)
+		if err == nil {
+			past = append(past, src)
+		} else if !errors.Is(err, errors.NotFound) {
+			return nil, false
+		}
+		src, err = readHead(p.opts.gitRoot, p.opts.rng, file)
+		if err == nil {
+			current = append(current, src)
+		} else if !os.IsNotExist(err) && !errors.Is(err, errors.NotFound) {
+			return nil, false
+		}
+	}
+	pastDecls, err := impact.Decls(past)
+	if err != nil {
+		return nil, false
+	}
+	currentDecls, err := impact.Decls(current)
+	if err != nil {
+		return nil, false
+	}
+	changed := impact.Diff(pastDecls, currentDecls)
+	if changed[impact.Init] {
+		return nil, false
+	}
+	return changed, true
+}
+
+// sameDirectives reports whether the comments affecting the build of a Go
+// file are the same at the base and head. Added and removed files have
+// none on one side, which is the same only if they have none on the other.
+func sameDirectives(gitRoot string, rng git.Range, file string) bool {
+	directives := func(src []byte, err error) (string, bool) {
+		if err != nil {
+			return "", os.IsNotExist(err) || errors.Is(err, errors.NotFound)
+		}
+		_, d, ok := normalizeGo(file, src)
+		return d, ok
+	}
+	past, ok := directives(readBase(gitRoot, rng, file))
+	if !ok {
+		return false
+	}
+	current, ok := directives(readHead(gitRoot, rng, file))
+	return ok && past == current
+}
+
+// sameExampleOutputs reports whether the expected outputs of the examples in
+// a Go test file are the same at the base and head.
+func sameExampleOutputs(gitRoot string, rng git.Range, file string) bool {
+	outputs := func(src []byte, err error) (string, bool) {
+		if err != nil {
+			return "", os.IsNotExist(err) || errors.Is(err, errors.NotFound)
+		}
+		return exampleOutputs(file, src), true
+	}
+	past, ok := outputs(readBase(gitRoot, rng, file))
+	if !ok {
+		return false
+	}
+	current, ok := outputs(readHead(gitRoot, rng, file))
+	return ok && past == current
+}
+
+// testsAffected reports whether the tests of a package that is not itself
+// affected refer to changed objects.
+func (p *precise) testsAffected(pkg packages.Package) bool {
+	for _, imports := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+		for _, importPath := range imports {
+			if p.whole[importPath] {
+				return true
+			}
+		}
+	}
+	if len(pkg.CgoFiles) > 0 {
+		return true
+	}
+	files := append(append([]string(nil), pkg.GoFiles...), pkg.TestGoFiles...)
+	internal, err := p.check(pkg, pkg.ImportPath, files, nil)
+	if err != nil || p.blankAffected(pkg, internal) || len(internal.Affected(nil, p.changed)) > 0 {
+		return true
+	}
+	if len(pkg.XTestGoFiles) == 0 {
+		return false
+	}
+	external, err := p.check(pkg, pkg.ImportPath+"_test", pkg.XTestGoFiles, internal.Types)
+	return err != nil || p.blankAffected(pkg, external) || len(external.Affected(nil, p.changed)) > 0
+}
+
+// check type-checks files of the package's directory as the package at
+// importPath, importing the package itself as self when it is set.
+func (p *precise) check(pkg packages.Package, importPath string, files []string, self *types.Package) (*impact.Package, error) {
+	imp, ok := p.importers[moduleDir(pkg)]
+	if !ok {
+		return nil, errors.NotFoundf("export data for %q", pkg.ImportPath)
+	}
+	paths := []string(nil)
+	for _, file := range files {
+		paths = append(paths, filepath.Join(pkg.Dir, file))
+	}
+	conf := &types.Config{
+		Importer: importerFunc(func(importPath string) (*types.Package, error) {
+			if mapped, ok := pkg.ImportMap[importPath]; ok {
+				importPath = mapped
+			}
+			if self != nil && importPath == self.Path() {
+				return self, nil
+			}
+			return imp.Import(importPath)
+		}),
+		Sizes: types.SizesFor(p.buildCtx.Compiler, p.buildCtx.GOARCH),
+	}
+	if pkg.Module.GoVersion != "" {
+		conf.GoVersion = "go" + pkg.Module.GoVersion
+	}
+	return impact.Check(p.fset, importPath, paths, conf)
+}
+
+// changed reports whether an object of another package has changed.
+func (p *precise) changed(obj impact.Object) bool {
+	if obj.Path == "" {
+		return p.methods[obj.Key]
+	}
+	return p.whole[obj.Path] || p.objects[obj.Path][obj.Key]
+}
+
+// blankAffected reports whether a package imported only for its effects is
+// affected, as nothing refers to it.
+func (p *precise) blankAffected(pkg packages.Package, checked *impact.Package) bool {
+	for _, importPath := range checked.Blank {
+		if mapped, ok := pkg.ImportMap[importPath]; ok {
+			importPath = mapped
+		}
+		if p.s.affected(importPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// importsAffected reports whether any of the imports is affected.
+func (p *precise) importsAffected(imports ...[]string) bool {
+	for _, importPaths := range imports {
+		for _, importPath := range importPaths {
+			if p.s.affected(importPath) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importsAny reports whether the package or its tests import any of the
+// packages in the set.
+func importsAny(pkg packages.Package, set map[string]bool) bool {
+	for _, imports := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+		for _, importPath := range imports {
+			if set[importPath] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string(nil)
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return f(path)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/gomod"
+)
+
+// ReasonCode classifies why a package was selected.
+type ReasonCode string
+
+const (
+	ReasonPackageChanged   ReasonCode = "package changed"
+	ReasonTestsChanged     ReasonCode = "tests changed"
+	ReasonTestDepsChanged  ReasonCode = "test deps changed"
+	ReasonNewDep           ReasonCode = "new dep"
+	ReasonChangedDep       ReasonCode = "changed dep"
+	ReasonRemovedDep       ReasonCode = "removed dep"
+	ReasonNewReplace       ReasonCode = "new replace"
+	ReasonChangedReplace   ReasonCode = "changed replace"
+	ReasonRemovedReplace   ReasonCode = "removed replace"
+	ReasonGoVersionChanged ReasonCode = "go mod version changed"
+	ReasonToolchainChanged ReasonCode = "toolchain changed"
+	ReasonLanguageChanged  ReasonCode = "language version changed"
+	ReasonStdChanged       ReasonCode = "std package changed"
+	ReasonGodebugDefault   ReasonCode = "godebug default changed"
+	ReasonGodebugChanged   ReasonCode = "godebug changed"
+	ReasonExcludeChanged   ReasonCode = "exclude changed"
+	ReasonToolChanged      ReasonCode = "tool changed"
+	ReasonNewGoMod         ReasonCode = "new go mod"
+	ReasonNewGoWork        ReasonCode = "new go work"
+	ReasonNewUse           ReasonCode = "new use"
+	ReasonRemovedUse       ReasonCode = "removed use"
+
+	ReasonPackageAdded       ReasonCode = "package added"
+	ReasonPackageRemoved     ReasonCode = "package removed"
+	ReasonImportsChanged     ReasonCode = "imports changed"
+	ReasonTestImportsChanged ReasonCode = "test imports changed"
+)
+
+// Reason is a single cause for a package being selected. Path is the
+// package or module the reason refers to.
+//
+// Chain is the shortest import chain from the selected package to the
+// package the reason belongs to, starting with the selected package. ViaTest
+// is set when the first edge of the chain is only imported by tests.
+//
+// Objects are the changed package-level objects of a changed package, keyed
+// as Name or Type.Method, when they are known. Detail describes the change
+// further, such as the old and new versions of a module. Event is the go.mod
+// directive change the reason comes from, if any.
+type Reason struct {
+	Code    ReasonCode
+	Path    string       `json:",omitempty"`
+	Sources []git.Source `json:",omitempty"`
+	Chain   []string     `json:",omitempty"`
+	ViaTest bool         `json:",omitempty"`
+	Objects []string     `json:",omitempty"`
+	Detail  string       `json:",omitempty"`
+	Event   *gomod.Event `json:",omitempty"`
+}
+
+func (r Reason) String() string {
+	if len(r.Chain) == 0 {
+		s := string(r.Code)
+		if r.Path != "" {
+			s += " " + r.Path
+		}
+		if r.Detail != "" {
+			s += " " + r.Detail
+		}
+		if len(r.Sources) > 0 {
+			s += " (" + r.sources() + ")"
+		}
+		return s
+	}
+	s := r.Chain[0]
+	for i, importPath := range r.Chain[1:] {
+		if i == 0 && r.ViaTest {
+			s += " -(test)-> " + importPath
+		} else {
+			s += " -> " + importPath
+		}
+	}
+	details := string(r.Code)
+	if r.Path != "" && r.Path != r.Chain[len(r.Chain)-1] {
+		details += " " + r.Path
+	}
+	if r.Detail != "" {
+		details += " " + r.Detail
+	}
+	if len(r.Sources) > 0 {
+		details += ", " + r.sources()
+	}
+	if len(r.Objects) > 0 {
+		details += "; " + strings.Join(r.Objects, ", ")
+	}
+	return s + " (" + details + ")"
+}
+
+func (r Reason) sources() string {
+	sources := []string(nil)
+	for _, source := range r.Sources {
+		sources = append(sources, string(source))
+	}
+	return strings.Join(sources, ", ")
+}
+
+// Selected is a package selected for testing, as emitted by --json.
+type Selected struct {
+	ImportPath   string
+	Dir          string   `json:",omitempty"`
+	Module       string   `json:",omitempty"`
+	ModuleDir    string   `json:",omitempty"` // directory containing the module's go.mod
+	Changed      bool     `json:",omitempty"` // the package or its module changed
+	TestsChanged bool     `json:",omitempty"` // the package's tests or test data changed
+	Dependency   bool     `json:",omitempty"` // a dependency of the package changed
+	Platform     string   `json:",omitempty"` // the --matrix entry the package was selected for
+	Suite        string   `json:",omitempty"` // unit or integration, with --integration-tags
+	Run          string   `json:",omitempty"` // -run pattern of the affected tests, with --run
+	Reasons      []Reason `json:",omitempty"`
+}
+
+// Ignored is a changed file, or a change to a go.mod directive, that
+// selected no packages, as emitted by --json.
+type Ignored struct {
+	Ignored  string // file relative to the git root
+	Reason   string
+	Event    *gomod.Event `json:",omitempty"`
+	Platform string       `json:",omitempty"`
+	Suite    string       `json:",omitempty"`
+}
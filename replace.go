@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// localReplacements returns the packages whose module is replaced by a
+// directory on the local filesystem.
+func localReplacements(pkgs []packages.Package) []packages.Package {
+	replaced := []packages.Package(nil)
+	for _, pkg := range pkgs {
+		rep := pkg.Module.Replace
+		if rep == nil || rep.Version != "" || rep.Dir == "" {
+			continue
+		}
+		replaced = append(replaced, pkg)
+	}
+	return replaced
+}
+
+// replacementChanges returns the changes in the git repositories, other than
+// gitRoot, containing the replacement directories of pkgs. Each repository
+// is compared using the same revision spec. Replacement directories that
+// cannot be compared, outside any git repository or in one lacking the
+// spec's revisions, are skipped, and the reason is returned for each.
+func replacementChanges(gitRoot, spec string, mergeBase bool, set git.ChangeSet, pkgs []packages.Package) ([]git.Change, map[string]string) {
+	roots := map[string]bool{gitRoot: true}
+	failed := map[string]string{}
+	undiffed := map[string]string{}
+	dirs := map[string]bool{}
+	changes := []git.Change(nil)
+	for _, pkg := range pkgs {
+		dir := pkg.Module.Replace.Dir
+		if dirs[dir] {
+			continue
+		}
+		dirs[dir] = true
+		root, err := git.Root(dir)
+		if err != nil {
+			undiffed[dir] = "outside any git repository"
+			continue
+		}
+		if reason, ok := failed[root]; ok {
+			undiffed[dir] = reason
+			continue
+		}
+		if roots[root] {
+			continue
+		}
+		roots[root] = true
+		rng, err := git.ParseRange(root, spec, mergeBase)
+		if err != nil {
+			failed[root] = "in a repository that cannot be diffed, " + oneLine(errors.Annotatef(err, "resolving %q in %s", spec, root).Error())
+			undiffed[dir] = failed[root]
+			continue
+		}
+		rng, err = rng.ForChanges(root, set)
+		if err != nil {
+			failed[root] = "in a repository that cannot be diffed, " + oneLine(errors.Annotatef(err, "diffing %s", root).Error())
+			undiffed[dir] = failed[root]
+			continue
+		}
+		rootChanges, err := git.DiffNames(root, rng, set)
+		if err != nil {
+			failed[root] = "in a repository that cannot be diffed, " + oneLine(errors.Annotatef(err, "diffing %s", root).Error())
+			undiffed[dir] = failed[root]
+			continue
+		}
+		changes = append(changes, rootChanges...)
+	}
+	return changes, undiffed
+}
+
+// oneLine joins the lines of an error message, such as one quoting git's
+// stderr.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
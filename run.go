@@ -0,0 +1,159 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/impact"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// testPrefixes are the prefixes of the functions go test -run runs.
+// Benchmarks only run with -bench.
+var testPrefixes = []string{"Test", "Example", "Fuzz"}
+
+// dynamicCalls are the reflect methods that call funcs and methods the
+// analysis cannot see.
+var dynamicCalls = []impact.Object{
+	{Path: "reflect", Key: "Value.Call"},
+	{Path: "reflect", Key: "Value.CallSlice"},
+	{Path: "reflect", Key: "Value.Method"},
+	{Path: "reflect", Key: "Value.MethodByName"},
+	{Path: "reflect", Key: "Type.Method"},
+	{Path: "reflect", Key: "Type.MethodByName"},
+}
+
+// runPattern returns a -run pattern matching the test, example and fuzz
+// functions of a selected package that refer to changed objects, directly
+// or through other declarations. It returns false when every test must
+// run, because the package changed as a whole, its test setup or init is
+// affected, or its tests call funcs through reflection.
+func (p *precise) runPattern(pkg packages.Package) (string, bool) {
+	if p.whole[pkg.ImportPath] || !p.compared[pkg.ImportPath] || len(pkg.CgoFiles) > 0 {
+		return "", false
+	}
+	for _, imports := range [][]string{pkg.Imports, pkg.TestImports, pkg.XTestImports} {
+		for _, importPath := range imports {
+			if p.whole[importPath] {
+				return "", false
+			}
+		}
+	}
+
+	internalChanges := []git.Change(nil)
+	externalChanges := []git.Change(nil)
+	for _, change := range p.s.files.test[path.Clean(pkg.Dir)] {
+		switch name := filepath.Base(change.Path); {
+		case contains(pkg.TestGoFiles, name):
+			internalChanges = append(internalChanges, change)
+		case contains(pkg.XTestGoFiles, name):
+			externalChanges = append(externalChanges, change)
+		default:
+			// Test data, embedded files and removed test files.
+			return "", false
+		}
+	}
+	internalChanged, ok := p.diff(pkg, pkg.TestGoFiles, internalChanges)
+	if !ok {
+		return "", false
+	}
+	externalChanged, ok := p.diff(pkg, pkg.XTestGoFiles, externalChanges)
+	if !ok {
+		return "", false
+	}
+	for key := range p.objects[pkg.ImportPath] {
+		internalChanged[key] = true
+	}
+
+	files := append(append([]string(nil), pkg.GoFiles...), pkg.TestGoFiles...)
+	internal, err := p.check(pkg, pkg.ImportPath, files, nil)
+	if err != nil || p.blankAffected(pkg, internal) {
+		return "", false
+	}
+	internalAffected := internal.Affected(internalChanged, p.changed)
+	tests, ok := p.affectedTests(internal, internalAffected, pkg.TestGoFiles)
+	if !ok {
+		return "", false
+	}
+	if len(pkg.XTestGoFiles) > 0 {
+		external, err := p.check(pkg, pkg.ImportPath+"_test", pkg.XTestGoFiles, internal.Types)
+		if err != nil || p.blankAffected(pkg, external) {
+			return "", false
+		}
+		externalAffected := external.Affected(externalChanged, func(obj impact.Object) bool {
+			if obj.Path == pkg.ImportPath {
+				return internalAffected[obj.Key]
+			}
+			return p.changed(obj)
+		})
+		externalTests, ok := p.affectedTests(external, externalAffected, pkg.XTestGoFiles)
+		if !ok {
+			return "", false
+		}
+		tests = append(tests, externalTests...)
+	}
+
+	// Run no tests, but still build the package and its tests, when none
+	// of them are affected.
+	if len(tests) == 0 {
+		return "^$", true
+	}
+	sort.Strings(tests)
+	return "^(" + strings.Join(tests, "|") + ")$", true
+}
+
+// affectedTests returns the affected test functions declared by a package
+// with the test files, or false if the tests' setup is affected or they
+// call funcs through reflection.
+func (p *precise) affectedTests(checked *impact.Package, affected map[string]bool, files []string) ([]string, bool) {
+	if len(files) == 0 {
+		return nil, true
+	}
+	if affected[impact.Init] || affected["TestMain"] {
+		return nil, false
+	}
+	for _, refs := range checked.Refs {
+		for _, obj := range dynamicCalls {
+			if refs[obj] {
+				return nil, false
+			}
+		}
+	}
+	tests := []string(nil)
+	for key := range affected {
+		if isTest(key) && p.inFiles(checked, key, files) {
+			tests = append(tests, key)
+		}
+	}
+	return tests, true
+}
+
+// isTest reports whether name is that of a function go test -run runs.
+func isTest(name string) bool {
+	for _, prefix := range testPrefixes {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(name) == len(prefix) {
+			return true
+		}
+		r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+		return !unicode.IsLower(r)
+	}
+	return false
+}
+
+// inFiles reports whether the package-level object named key is declared in
+// one of the files.
+func (p *precise) inFiles(checked *impact.Package, key string, files []string) bool {
+	obj := checked.Types.Scope().Lookup(key)
+	if obj == nil {
+		return false
+	}
+	return contains(files, filepath.Base(p.fset.Position(obj.Pos()).Filename))
+}
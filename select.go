@@ -0,0 +1,336 @@
+package main
+
+import (
+	"go/build"
+	"path"
+	"path/filepath"
+
+	"github.com/dominikbraun/graph"
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// options are the inputs to selecting packages that do not depend on the
+// build context.
+type options struct {
+	wd        string
+	gitRoot   string
+	treeish   string
+	mergeBase bool
+	rng       git.Range
+	changes   git.ChangeSet
+	ignore    []string
+	patterns  []string
+
+	// ignoreComments ignores Go files with comment-only changes.
+	ignoreComments bool
+	// precise selects only the importers that refer to changed objects.
+	precise bool
+	// depSources only marks the packages of upgraded modules whose files
+	// differ from the base version in the module cache.
+	depSources bool
+	// baseGraph also loads the packages at the base to find removed
+	// packages and changed imports.
+	baseGraph bool
+	// run finds the tests of each selected package that refer to changed
+	// objects. It requires precise.
+	run bool
+
+	// changedFiles is the diff of gitRoot.
+	changedFiles []git.Change
+}
+
+// selection is the set of packages that need testing for one build context,
+// along with the state used to decide it.
+type selection struct {
+	patterns     []string
+	pkgs         []packages.Package
+	extraPkgs    []packages.Package
+	replacedPkgs []packages.Package
+	pkgsByPath   map[string]packages.Package
+	files        *classifier
+
+	changedPackages     map[string]bool
+	changedTestPackages map[string]bool
+	dependencyChanged   map[string]bool
+	testDepsChanged     map[string]bool
+	needsTest           map[string]bool
+	whyChanged          map[string][]Reason
+	whyChangedTests     map[string][]Reason
+
+	// runs are the -run patterns of the packages whose affected tests are
+	// known.
+	runs map[string]string
+}
+
+// selectPackages loads the packages matching the patterns for buildCtx and
+// selects those affected by the changes.
+func selectPackages(opts *options, buildCtx build.Context) (*selection, error) {
+	s := &selection{
+		patterns:            opts.patterns,
+		pkgsByPath:          make(map[string]packages.Package),
+		changedPackages:     make(map[string]bool),
+		changedTestPackages: make(map[string]bool),
+		dependencyChanged:   make(map[string]bool),
+		testDepsChanged:     make(map[string]bool),
+		needsTest:           make(map[string]bool),
+		whyChanged:          make(map[string][]Reason),
+		whyChangedTests:     make(map[string][]Reason),
+		runs:                make(map[string]string),
+	}
+	modChanges := newModChanges()
+	// Only the module cache is compared with --dep-sources, so the build
+	// lists are loaded from it too.
+	modChanges.offline = opts.depSources
+
+	workFile, err := packages.Workspace(buildCtx, opts.wd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if workFile != "" {
+		moduleDirs, err := compareWorkFile(opts.gitRoot, opts.rng, workFile, modChanges)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.patterns = packages.ExpandPatterns(opts.wd, moduleDirs, s.patterns)
+		s.pkgs, s.extraPkgs, err = packages.ImportAll(buildCtx, opts.wd, s.patterns)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	} else {
+		moduleDirs, err := packages.Modules(buildCtx, opts.wd)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.patterns = packages.ExpandPatterns(opts.wd, moduleDirs, s.patterns)
+		groups := packages.GroupPatterns(opts.wd, moduleDirs, s.patterns)
+		s.pkgs, s.extraPkgs, err = packages.ImportModules(buildCtx, groups)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		// Compare the go.mod file of every module matched by the patterns.
+		modFiles := map[string]bool{}
+		for _, pkg := range s.pkgs {
+			if pkg.Module.GoMod != "" {
+				modFiles[pkg.Module.GoMod] = true
+			}
+		}
+		for modFile := range modFiles {
+			err := compareModFile(opts.gitRoot, opts.rng, modFile, modChanges)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+	}
+
+	// Vendored modules are compared by their vendored files rather than
+	// their requirements.
+	vendorDirs := map[string]bool{}
+	if workFile != "" {
+		vendorDirs[filepath.Dir(workFile)] = true
+	}
+	for _, pkg := range s.pkgs {
+		vendorDirs[moduleDir(pkg)] = true
+	}
+	whyChangedVendored := make(map[string][]Reason)
+	for dir := range vendorDirs {
+		vendored, err := compareVendor(opts.gitRoot, opts.rng, dir, modChanges.modules, whyChangedVendored)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for modPath := range vendored {
+			reasons := modChanges.modules[modPath][:0]
+			for _, reason := range modChanges.modules[modPath] {
+				if reason.Code == ReasonGoVersionChanged {
+					reasons = append(reasons, reason)
+				}
+			}
+			modChanges.modules[modPath] = reasons
+			delete(modChanges.past, modPath)
+		}
+	}
+
+	s.replacedPkgs = localReplacements(s.extraPkgs)
+	replacedFiles, undiffed := replacementChanges(opts.gitRoot, opts.treeish, opts.mergeBase, opts.changes, s.replacedPkgs)
+	changedFiles := append(append([]git.Change(nil), opts.changedFiles...), replacedFiles...)
+
+	comparedPkgs := append(append([]packages.Package(nil), s.pkgs...), s.replacedPkgs...)
+	comparedPkgs = append(comparedPkgs, vendoredPackages(s.extraPkgs, s.replacedPkgs, opts.gitRoot)...)
+	s.files = newClassifier(opts, comparedPkgs)
+	for _, change := range changedFiles {
+		s.files.add(change)
+	}
+	s.files.ignored = append(s.files.ignored, modChanges.ignored...)
+
+	allPkgs := append(append([]packages.Package(nil), s.pkgs...), s.extraPkgs...)
+	// Mark every package belonging to a changed module as changed, as a
+	// whole rather than by its objects.
+	wholeChanged := make(map[string]bool)
+	for _, v := range allPkgs {
+		if len(modChanges.modules[v.Module.Path]) == 0 {
+			continue
+		}
+		if past, ok := modChanges.past[v.Module.Path]; ok && opts.depSources && sameDepSources(v, past) {
+			continue
+		}
+		wholeChanged[v.ImportPath] = true
+		s.changedPackages[v.ImportPath] = true
+		s.whyChanged[v.ImportPath] = append(s.whyChanged[v.ImportPath], modChanges.modules[v.Module.Path]...)
+	}
+	// Replacements that cannot be diffed may have changed anywhere.
+	for _, v := range s.replacedPkgs {
+		if reason, ok := undiffed[v.Module.Replace.Dir]; ok {
+			wholeChanged[v.ImportPath] = true
+			s.changedPackages[v.ImportPath] = true
+			s.whyChanged[v.ImportPath] = append(s.whyChanged[v.ImportPath], Reason{Code: ReasonPackageChanged, Path: v.ImportPath, Detail: reason})
+		}
+	}
+	for _, v := range s.pkgs {
+		for tool, reasons := range modChanges.tools[v.Module.Path] {
+			if generates(v, tool) {
+				wholeChanged[v.ImportPath] = true
+				s.changedPackages[v.ImportPath] = true
+				s.whyChanged[v.ImportPath] = append(s.whyChanged[v.ImportPath], reasons...)
+			}
+		}
+	}
+	if err := s.compareToolchains(modChanges, allPkgs, wholeChanged); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, v := range comparedPkgs {
+		dir := path.Clean(v.Dir)
+		if dirChanges := s.files.build[dir]; len(dirChanges) > 0 {
+			s.changedPackages[v.ImportPath] = true
+			s.whyChanged[v.ImportPath] = append(s.whyChanged[v.ImportPath], Reason{Code: ReasonPackageChanged, Path: v.ImportPath, Sources: changeSources(dirChanges)})
+			s.whyChanged[v.ImportPath] = append(s.whyChanged[v.ImportPath], whyChangedVendored[v.Module.Path]...)
+		}
+		if dirChanges := s.files.test[dir]; len(dirChanges) > 0 {
+			s.changedTestPackages[v.ImportPath] = true
+			s.whyChangedTests[v.ImportPath] = append(s.whyChangedTests[v.ImportPath], Reason{Code: ReasonTestsChanged, Path: v.ImportPath, Sources: changeSources(dirChanges)})
+		}
+	}
+
+	for _, pkg := range allPkgs {
+		s.pkgsByPath[pkg.ImportPath] = pkg
+	}
+
+	if opts.baseGraph {
+		basePkgs, worktree, err := loadBase(opts, buildCtx)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s.compareBase(basePkgs, worktree, opts.gitRoot, wholeChanged)
+	}
+
+	g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
+	for _, pkg := range allPkgs {
+		err := g.AddVertex(pkg.ImportPath)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	for _, pkg := range allPkgs {
+		for _, importPath := range pkg.Imports {
+			g.AddEdge(pkg.ImportPath, importPath)
+		}
+	}
+
+	if opts.precise {
+		err := s.propagateObjects(opts, buildCtx, g, comparedPkgs, wholeChanged)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return s, nil
+	}
+
+	for _, pkg := range allPkgs {
+		if s.changedTestPackages[pkg.ImportPath] {
+			s.needsTest[pkg.ImportPath] = true
+		}
+		if !s.changedPackages[pkg.ImportPath] {
+			continue
+		}
+		s.needsTest[pkg.ImportPath] = true
+		ReverseDFS(g, pkg.ImportPath, func(importPath string) bool {
+			s.needsTest[importPath] = true
+			if importPath != pkg.ImportPath {
+				s.dependencyChanged[importPath] = true
+			}
+			return false
+		})
+	}
+
+	extraNeedsTest := make(map[string]bool)
+nextPackage:
+	for _, pkg := range allPkgs {
+		for _, importPath := range pkg.TestImports {
+			if s.needsTest[importPath] {
+				extraNeedsTest[pkg.ImportPath] = true
+			}
+		}
+		for _, importPath := range pkg.XTestImports {
+			if s.needsTest[importPath] {
+				extraNeedsTest[pkg.ImportPath] = true
+				continue nextPackage
+			}
+		}
+	}
+
+	for importPath := range extraNeedsTest {
+		s.needsTest[importPath] = true
+		s.testDepsChanged[importPath] = true
+		s.whyChangedTests[importPath] = append(s.whyChangedTests[importPath], Reason{Code: ReasonTestDepsChanged})
+	}
+
+	return s, nil
+}
+
+// write writes the selected packages matching the patterns to out.
+func (s *selection) write(out *printer) error {
+	for _, pkg := range s.pkgs {
+		importPath := pkg.ImportPath
+		if !s.needsTest[importPath] {
+			continue
+		}
+		selected := Selected{
+			ImportPath:   importPath,
+			Dir:          pkg.Dir,
+			Module:       pkg.Module.Path,
+			ModuleDir:    moduleDir(pkg),
+			Changed:      s.changedPackages[importPath],
+			TestsChanged: s.changedTestPackages[importPath],
+			Dependency:   s.dependencyChanged[importPath] || s.testDepsChanged[importPath],
+			Run:          s.runs[importPath],
+		}
+		if out.why || out.json {
+			for _, reason := range s.whyChangedTests[importPath] {
+				if reason.Code != ReasonTestDepsChanged {
+					reason.Chain = []string{importPath}
+				}
+				selected.Reasons = append(selected.Reasons, reason)
+			}
+			selected.Reasons = append(selected.Reasons, explain(s.pkgsByPath, importPath, s.whyChanged, s.affected)...)
+		}
+		if err := out.selected(selected); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// writeIgnored explains the changed files that selected nothing.
+func (s *selection) writeIgnored(out *printer, gitRoot string) {
+	for _, ignored := range s.files.ignored {
+		out.ignored(gitPath(gitRoot, ignored.Path), ignored.Reason, ignored.Event)
+	}
+}
+
+// affected reports whether the package itself, rather than only its tests,
+// is affected by the changes, so that its importers are too.
+func (s *selection) affected(importPath string) bool {
+	return s.changedPackages[importPath] || s.dependencyChanged[importPath]
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/version"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// languageGate is a language version that changed the meaning of existing
+// code.
+type languageGate struct {
+	version  string
+	change   string
+	affected func(pkg packages.Package) bool
+}
+
+var languageGates = []languageGate{
+	{"go1.22", "per-loop variables", capturesLoopVars},
+}
+
+// compareToolchains marks the packages affected by changes to the go and
+// toolchain directives of the modules in changes. Packages of a module
+// whose language version crossed a gate are changed if the gate can affect
+// them. When the selected toolchain changed, and both toolchains are
+// available locally, the packages of the standard library whose sources
+// differ between them are changed, as are those whose GODEBUG defaults
+// changed with the language version. Otherwise, every package of the module
+// is changed. The packages marked are added to whole.
+func (s *selection) compareToolchains(changes *modChanges, pkgs []packages.Package, whole map[string]bool) error {
+	if len(changes.toolchains) == 0 {
+		return nil
+	}
+	t, err := packages.DefaultToolchain()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for modPath, change := range changes.toolchains {
+		marked := false
+		changed := func(importPath string, reason Reason) {
+			s.changedPackages[importPath] = true
+			s.whyChanged[importPath] = append(s.whyChanged[importPath], reason)
+			whole[importPath] = true
+			marked = true
+		}
+
+		pastLang, lang := langVersion(change.pastGo), langVersion(change.goVersion)
+		for _, gate := range languageGates {
+			if !crosses(pastLang, lang, gate.version) {
+				continue
+			}
+			reason := Reason{Code: ReasonLanguageChanged, Path: modPath, Detail: fmt.Sprintf("%s => %s, %s", pastLang, lang, gate.change), Event: change.goEvent}
+			for _, pkg := range pkgs {
+				if pkg.Module.Path == modPath && gate.affected(pkg) {
+					changed(pkg.ImportPath, reason)
+				}
+			}
+		}
+
+		past, current := selectToolchain(t, change.pastGo, change.pastName), selectToolchain(t, change.goVersion, change.name)
+		pastRoot, root := t.Root(past), t.Root(current)
+		if past != current && (pastRoot == "" || root == "") {
+			reason := Reason{Code: ReasonToolchainChanged, Path: modPath, Detail: past + " => " + current + ", not available locally", Event: change.selecting()}
+			for _, pkg := range pkgs {
+				if pkg.Module.Path == modPath {
+					changed(pkg.ImportPath, reason)
+				}
+			}
+			continue
+		}
+		if past != current {
+			for _, pkg := range pkgs {
+				dir, pastDir := filepath.Join(root, "src", pkg.ImportPath), filepath.Join(pastRoot, "src", pkg.ImportPath)
+				if pkg.Standard && !sameSources(dir, pastDir, pkg.EmbedPatterns) {
+					changed(pkg.ImportPath, Reason{Code: ReasonStdChanged, Path: pkg.ImportPath, Detail: past + " => " + current, Event: change.selecting()})
+				}
+			}
+		}
+		if pastLang != lang {
+			if root == "" {
+				root = t.GOROOT
+			}
+			for _, setting := range godebugDefaults(root) {
+				if !crosses(pastLang, lang, setting.version) {
+					continue
+				}
+				for _, pkg := range pkgs {
+					if pkg.Standard && pkg.ImportPath == setting.pkg {
+						changed(pkg.ImportPath, Reason{Code: ReasonGodebugDefault, Path: pkg.ImportPath, Detail: setting.name + " in " + setting.version, Event: change.goEvent})
+					}
+				}
+			}
+		}
+
+		if !marked {
+			reason := fmt.Sprintf("toolchain %s selected before and after, affects no packages", current)
+			if past != current {
+				reason = fmt.Sprintf("toolchains %s and %s have the same standard library, affects no packages", past, current)
+			}
+			s.files.ignored = append(s.files.ignored, ignoredChange{Change: git.Change{Path: change.file}, Reason: reason, Event: change.selecting()})
+		}
+	}
+	return nil
+}
+
+// selectToolchain returns the toolchain the go command runs for a go.mod
+// file with the go and toolchain directives. The default toolchain, t, is
+// only switched for a newer one when GOTOOLCHAIN allows it.
+func selectToolchain(t packages.Toolchain, goLine, name string) string {
+	_, _, switches := strings.Cut(t.GOTOOLCHAIN, "+")
+	if !switches && t.GOTOOLCHAIN != "auto" && t.GOTOOLCHAIN != "path" {
+		return t.GOVERSION
+	}
+	required := "go" + goLine
+	if version.Lang(required) == required && version.Compare(required, "go1.21") >= 0 {
+		// The go command downloads the first release of a language
+		// version.
+		required += ".0"
+	}
+	if name != "" && name != "default" && version.Compare(name, required) > 0 {
+		required = name
+	}
+	if version.Compare(required, t.GOVERSION) > 0 {
+		return required
+	}
+	return t.GOVERSION
+}
+
+// langVersion returns the language version of a go directive, which is
+// go1.16 when there is none.
+func langVersion(goLine string) string {
+	if goLine == "" {
+		return "go1.16"
+	}
+	return version.Lang("go" + goLine)
+}
+
+// crosses reports whether changing between the versions a and b, in either
+// direction, crosses gate.
+func crosses(a, b, gate string) bool {
+	if version.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+	return version.Compare(a, gate) < 0 && version.Compare(b, gate) >= 0
+}
+
+// godebugSetting is a GODEBUG setting whose default changed with a
+// language version.
+type godebugSetting struct {
+	name    string
+	pkg     string
+	version string
+}
+
+var godebugEntry = regexp.MustCompile(`Name:\s*"([^"]+)",\s*Package:\s*"([^"]+)",\s*Changed:\s*(\d+)`)
+
+// godebugDefaults returns the settings whose defaults changed with a
+// language version, from the internal/godebugs table of the toolchain at
+// root.
+func godebugDefaults(root string) []godebugSetting {
+	src, err := os.ReadFile(filepath.Join(root, "src", "internal", "godebugs", "table.go"))
+	if err != nil {
+		return nil
+	}
+	settings := []godebugSetting(nil)
+	for _, m := range godebugEntry.FindAllStringSubmatch(string(src), -1) {
+		settings = append(settings, godebugSetting{name: m[1], pkg: m[2], version: "go1." + m[3]})
+	}
+	return settings
+}
+
+// capturesLoopVars reports whether a loop in the Go files of pkg, including
+// its tests, declares variables that its body may capture: by a function
+// literal, by taking an address or by calling a method, which may have a
+// pointer receiver.
+func capturesLoopVars(pkg packages.Package) bool {
+	fset := token.NewFileSet()
+	for _, files := range [][]string{pkg.GoFiles, pkg.CgoFiles, pkg.TestGoFiles, pkg.XTestGoFiles} {
+		for _, file := range files {
+			f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, file), nil, parser.SkipObjectResolution)
+			if err != nil {
+				return true
+			}
+			captures := false
+			ast.Inspect(f, func(n ast.Node) bool {
+				if captures {
+					return false
+				}
+				vars := map[string]bool{}
+				var body *ast.BlockStmt
+				switch loop := n.(type) {
+				case *ast.ForStmt:
+					if init, ok := loop.Init.(*ast.AssignStmt); ok && init.Tok == token.DEFINE {
+						for _, lhs := range init.Lhs {
+							vars[identName(lhs)] = true
+						}
+					}
+					body = loop.Body
+				case *ast.RangeStmt:
+					if loop.Tok == token.DEFINE {
+						vars[identName(loop.Key)] = true
+						vars[identName(loop.Value)] = true
+					}
+					body = loop.Body
+				default:
+					return true
+				}
+				delete(vars, "")
+				delete(vars, "_")
+				if len(vars) > 0 && capturesVars(body, vars) {
+					captures = true
+				}
+				return !captures
+			})
+			if captures {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// capturesVars reports whether body may capture any of vars.
+func capturesVars(body *ast.BlockStmt, vars map[string]bool) bool {
+	captures := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			captures = true
+		case *ast.UnaryExpr:
+			if n.Op == token.AND && vars[rootName(n.X)] {
+				captures = true
+			}
+		case *ast.CallExpr:
+			if sel, ok := n.Fun.(*ast.SelectorExpr); ok && vars[rootName(sel.X)] {
+				captures = true
+			}
+		}
+		return !captures
+	})
+	return captures
+}
+
+func identName(expr ast.Expr) string {
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// rootName returns the name of the variable an addressable expression, such
+// as v.f[i], is rooted at.
+func rootName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			return e.Name
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.SelectorExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		default:
+			return ""
+		}
+	}
+}
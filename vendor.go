@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// compareVendor compares the vendor/modules.txt file of the module or
+// workspace in dir between the base and head of rng, returning the modules
+// vendored at head. Vendored modules whose go version changed are recorded
+// in changed, as that changes all of their packages. The reasons for
+// vendored modules that are new or whose version changed are recorded in
+// versions, as they only explain the vendored packages whose files changed.
+func compareVendor(gitRoot string, rng git.Range, dir string, changed, versions map[string][]Reason) (map[string]packages.VendoredModule, error) {
+	file := filepath.Join(dir, "vendor", "modules.txt")
+	if !packages.Within(file, gitRoot) {
+		return nil, nil
+	}
+	currentFile, err := readHead(gitRoot, rng, file)
+	if os.IsNotExist(err) || errors.Is(err, errors.NotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	current := packages.ParseVendorModules(currentFile)
+
+	pastFile, err := readBase(gitRoot, rng, file)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return nil, errors.Trace(err)
+	}
+	past := packages.ParseVendorModules(pastFile)
+
+	for modPath, m := range current {
+		pastModule, ok := past[modPath]
+		if !ok {
+			versions[modPath] = append(versions[modPath], Reason{Code: ReasonNewDep, Path: modPath, Detail: "vendored " + vendoredVersion(m)})
+			continue
+		}
+		if version, pastVersion := vendoredVersion(m), vendoredVersion(pastModule); version != pastVersion {
+			versions[modPath] = append(versions[modPath], Reason{Code: ReasonChangedDep, Path: modPath, Detail: "vendored " + pastVersion + " => " + version})
+		}
+		if m.GoVersion != pastModule.GoVersion {
+			changed[modPath] = append(changed[modPath], Reason{Code: ReasonGoVersionChanged, Path: modPath, Detail: "vendored"})
+		}
+	}
+	return current, nil
+}
+
+// vendoredVersion returns the version of a vendored module, along with its
+// replacement.
+func vendoredVersion(m packages.VendoredModule) string {
+	if m.Replace == "" {
+		return m.Version
+	}
+	return strings.TrimSpace(m.Version + " => " + m.Replace)
+}
+
+// vendoredPackages returns the packages vendored in a vendor directory
+// under gitRoot, whether by module vendoring or, as ImportMap records for
+// their importers, by GOPATH vendoring. Packages in skip are left out.
+func vendoredPackages(pkgs, skip []packages.Package, gitRoot string) []packages.Package {
+	skipped := map[string]bool{}
+	for _, pkg := range skip {
+		skipped[pkg.ImportPath] = true
+	}
+	vendored := []packages.Package(nil)
+	for _, pkg := range pkgs {
+		if !skipped[pkg.ImportPath] && packages.Within(pkg.Dir, gitRoot) && inVendor(pkg.Dir, gitRoot) {
+			vendored = append(vendored, pkg)
+		}
+	}
+	return vendored
+}
+
+// inVendor reports whether dir, under root, is in a vendor directory.
+func inVendor(dir, root string) bool {
+	for _, elem := range strings.Split(filepath.ToSlash(gitPath(root, dir)), "/") {
+		if elem == "vendor" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// explain returns the reasons for start being selected. Each reason from
+// changed is attached to the shortest import chain from start to the package
+// it belongs to, through affected packages. Only start's own tests
+// contribute test-only edges, since the tests of its dependencies are not
+// compiled when testing start.
+func explain(pkgsByPath map[string]packages.Package, start string, changed map[string][]Reason, affected func(string) bool) []Reason {
+	type node struct {
+		importPath string
+		parent     *node
+		viaTest    bool
+	}
+	reasons := []Reason(nil)
+	visited := map[string]bool{start: true}
+	queue := []*node{{importPath: start}}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if rs := changed[current.importPath]; len(rs) > 0 {
+			chain := []string(nil)
+			viaTest := false
+			for n := current; n != nil; n = n.parent {
+				chain = append([]string{n.importPath}, chain...)
+				viaTest = viaTest || n.viaTest
+			}
+			for _, r := range rs {
+				r.Chain = chain
+				r.ViaTest = viaTest
+				reasons = append(reasons, r)
+			}
+		}
+
+		pkg := pkgsByPath[current.importPath]
+		for _, importPath := range pkg.Imports {
+			if !visited[importPath] && affected(importPath) {
+				visited[importPath] = true
+				queue = append(queue, &node{importPath: importPath, parent: current})
+			}
+		}
+		if current.parent != nil {
+			continue
+		}
+		for _, imports := range [][]string{pkg.TestImports, pkg.XTestImports} {
+			for _, importPath := range imports {
+				if !visited[importPath] && affected(importPath) {
+					visited[importPath] = true
+					queue = append(queue, &node{importPath: importPath, parent: current, viaTest: true})
+				}
+			}
+		}
+	}
+	return reasons
+}
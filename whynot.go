@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// whyNot describes the state gochanged used to decide whether a package is
+// selected, for debugging selections with --why-not.
+type whyNot struct {
+	*selection
+	w       io.Writer
+	wd      string
+	gitRoot string
+}
+
+// report writes why target, an import path or a directory relative to the
+// working directory, was or was not selected.
+func (wn *whyNot) report(target string) {
+	pkg, ok := wn.find(target)
+	if !ok {
+		fmt.Fprintf(wn.w, "%s => not found in go list results for %s\n", target, strings.Join(wn.patterns, " "))
+		return
+	}
+	importPath := pkg.ImportPath
+	matched := false
+	for _, p := range wn.pkgs {
+		if p.ImportPath == importPath {
+			matched = true
+			break
+		}
+	}
+	switch {
+	case wn.needsTest[importPath] && matched:
+		fmt.Fprintf(wn.w, "%s => selected, see --why\n", importPath)
+	case wn.needsTest[importPath]:
+		fmt.Fprintf(wn.w, "%s => not selected, affected but filtered out by %s\n", importPath, strings.Join(wn.patterns, " "))
+	default:
+		fmt.Fprintf(wn.w, "%s => not selected\n", importPath)
+	}
+
+	compared := false
+	for _, p := range append(append([]packages.Package(nil), wn.pkgs...), wn.replacedPkgs...) {
+		if p.ImportPath == importPath {
+			compared = true
+			break
+		}
+	}
+	if !compared {
+		fmt.Fprintf(wn.w, "	not matched by %s, only loaded as a dependency and not from a local replacement, so its files are not compared\n", strings.Join(wn.patterns, " "))
+	}
+
+	dir := path.Clean(pkg.Dir)
+	files := wn.files.build[dir]
+	testFiles := wn.files.test[dir]
+	ignored := []ignoredChange(nil)
+	for _, change := range wn.files.ignored {
+		if path.Dir(change.Path) == dir {
+			ignored = append(ignored, change)
+		}
+	}
+	if len(files) == 0 && len(testFiles) == 0 && len(ignored) == 0 {
+		fmt.Fprintf(wn.w, "	no changed files in %s\n", wn.rel(dir))
+	}
+	for _, change := range ignored {
+		fmt.Fprintf(wn.w, "	ignored file %s (%s): %s\n", wn.rel(change.Path), change.Source, change.Reason)
+	}
+	for _, change := range files {
+		fmt.Fprintf(wn.w, "	changed file %s (%s)\n", wn.rel(change.Path), change.Source)
+	}
+	for _, change := range testFiles {
+		kind := "test file"
+		if !strings.HasSuffix(change.Path, "_test.go") {
+			kind = "test data file"
+		}
+		fmt.Fprintf(wn.w, "	changed %s %s (%s), only selects this package\n", kind, wn.rel(change.Path), change.Source)
+	}
+
+	wn.imports("imports", pkg.Imports)
+	wn.imports("test imports", pkg.TestImports)
+	wn.imports("xtest imports", pkg.XTestImports)
+}
+
+func (wn *whyNot) imports(kind string, imports []string) {
+	if len(imports) == 0 {
+		return
+	}
+	fmt.Fprintf(wn.w, "	%s:\n", kind)
+	for _, importPath := range imports {
+		state := "unchanged"
+		if wn.needsTest[importPath] {
+			state = "selected"
+		} else if wn.changedTestPackages[importPath] && !wn.changedPackages[importPath] {
+			state = "only tests changed, does not propagate"
+		} else if _, ok := wn.pkgsByPath[importPath]; !ok {
+			state = "missing from go list results"
+		}
+		fmt.Fprintf(wn.w, "		%s (%s)\n", importPath, state)
+	}
+}
+
+func (wn *whyNot) find(target string) (packages.Package, bool) {
+	if pkg, ok := wn.pkgsByPath[target]; ok {
+		return pkg, true
+	}
+	dir := target
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(wn.wd, dir)
+	}
+	for _, pkg := range wn.pkgsByPath {
+		if pkg.Dir != "" && filepath.Clean(pkg.Dir) == dir {
+			return pkg, true
+		}
+	}
+	return packages.Package{}, false
+}
+
+func (wn *whyNot) rel(file string) string {
+	if rel, err := filepath.Rel(wn.gitRoot, file); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return file
+}
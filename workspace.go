@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/juju/errors"
+	"golang.org/x/mod/modfile"
+
+	"github.com/hpidcock/gochanged/git"
+	"github.com/hpidcock/gochanged/packages"
+)
+
+// compareWorkFile compares the go.work file and the go.mod file of every
+// module it uses between the base and head of rng, recording the changes in
+// changes. It returns the directories of the modules used at head.
+func compareWorkFile(gitRoot string, rng git.Range, file string, changes *modChanges) ([]string, error) {
+	if !packages.Within(file, gitRoot) {
+		return nil, errors.Errorf("%s is not under git root %s", file, gitRoot)
+	}
+	subpath := gitPath(gitRoot, file)
+	workDir := filepath.Dir(file)
+
+	currentWorkFile, err := readHead(gitRoot, rng, file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	currentWork, err := modfile.ParseWork(subpath, currentWorkFile, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	moduleDirs := []string(nil)
+	modulePaths := map[string]string{}
+	for _, use := range currentWork.Use {
+		dir := useDir(workDir, use.Path)
+		modFile, err := readHead(gitRoot, rng, filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, errors.Annotatef(err, "reading go.mod used by %s", subpath)
+		}
+		moduleDirs = append(moduleDirs, dir)
+		modulePaths[dir] = modfile.ModulePath(modFile)
+	}
+
+	for _, dir := range moduleDirs {
+		err := compareModFile(gitRoot, rng, filepath.Join(dir, "go.mod"), changes)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	pastWorkFile, err := readBase(gitRoot, rng, file)
+	if errors.Is(err, errors.NotFound) {
+		for _, dir := range moduleDirs {
+			modPath := modulePaths[dir]
+			changes.modules[modPath] = append(changes.modules[modPath], Reason{Code: ReasonNewGoWork, Path: modPath})
+		}
+		return moduleDirs, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	pastWork, err := modfile.ParseWork(subpath, pastWorkFile, nil)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if goVersion(currentWork.Go) != goVersion(pastWork.Go) {
+		for _, dir := range moduleDirs {
+			modPath := modulePaths[dir]
+			changes.modules[modPath] = append(changes.modules[modPath], Reason{Code: ReasonGoVersionChanged, Path: modPath})
+		}
+	}
+
+	pastUse := map[string]bool{}
+	for _, use := range pastWork.Use {
+		pastUse[useDir(workDir, use.Path)] = true
+	}
+	for _, dir := range moduleDirs {
+		if pastUse[dir] {
+			delete(pastUse, dir)
+			continue
+		}
+		modPath := modulePaths[dir]
+		changes.modules[modPath] = append(changes.modules[modPath], Reason{Code: ReasonNewUse, Path: modPath})
+	}
+	// Packages of modules no longer used now resolve from elsewhere.
+	for dir := range pastUse {
+		modFile, err := readBase(gitRoot, rng, filepath.Join(dir, "go.mod"))
+		if err != nil {
+			return nil, errors.Annotatef(err, "reading go.mod previously used by %s", subpath)
+		}
+		modPath := modfile.ModulePath(modFile)
+		changes.modules[modPath] = append(changes.modules[modPath], Reason{Code: ReasonRemovedUse, Path: modPath})
+	}
+
+	compareReplaces(pastWork.Replace, currentWork.Replace, changes.modules)
+	return moduleDirs, nil
+}
+
+func useDir(workDir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(workDir, path)
+}